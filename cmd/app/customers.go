@@ -2,11 +2,18 @@ package app
 
 import (
 	"encoding/json"
+	"errors"
+	"io"
 	"log"
 	"net/http"
+	"strconv"
 
 	"github.com/SardorMS/CRUD/cmd/app/middleware"
+	"github.com/SardorMS/CRUD/pkg/customers"
+	"github.com/SardorMS/CRUD/pkg/logins"
+	"github.com/SardorMS/CRUD/pkg/sales"
 	"github.com/SardorMS/CRUD/pkg/types"
+	"github.com/gorilla/mux"
 )
 
 // handleCustomerRegistration - registrate customers.
@@ -21,6 +28,17 @@ func (s *Server) handleCustomerRegistration(writer http.ResponseWriter, request
 	}
 
 	saved, err := s.customersSvc.Register(request.Context(), item)
+	var validationErr *types.ValidationError
+	if errors.As(err, &validationErr) {
+		log.Println(err)
+		respondValidationError(writer, validationErr)
+		return
+	}
+	if errors.Is(err, customers.ErrPhoneUsed) {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusConflict), http.StatusConflict)
+		return
+	}
 	if err != nil {
 		log.Println(err)
 		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
@@ -31,28 +49,257 @@ func (s *Server) handleCustomerRegistration(writer http.ResponseWriter, request
 
 // handleCustomerGetToken - generate token for registred customers.
 func (s *Server) handleCustomerGetToken(writer http.ResponseWriter, request *http.Request) {
-	var item *types.Auth
+	item := &types.Auth{}
+
+	err := json.NewDecoder(request.Body).Decode(item)
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	token, err := s.customersSvc.Token(request.Context(), item.Login, item.Password, clientInfo(request))
+	var lockedErr *logins.LockedError
+	if errors.As(err, &lockedErr) {
+		log.Println(err)
+		respondLocked(writer, lockedErr)
+		return
+	}
+	if errors.Is(err, customers.ErrNoSuchUser) || errors.Is(err, customers.ErrInvalidPassword) {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(writer, token)
+}
+
+// handleCustomerRefreshToken - exchanges refresh token for a new token.
+func (s *Server) handleCustomerRefreshToken(writer http.ResponseWriter, request *http.Request) {
+	var item *types.Refresh
 
 	err := json.NewDecoder(request.Body).Decode(&item)
+	if err != nil || item == nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	token, err := s.customersSvc.RefreshToken(request.Context(), item.RefreshToken, clientInfo(request))
+	if errors.Is(err, customers.ErrTokenNotFound) || errors.Is(err, customers.ErrTokenExpired) {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(writer, token)
+}
+
+// handleCustomerRevokeToken - revokes the current token (log out).
+func (s *Server) handleCustomerRevokeToken(writer http.ResponseWriter, request *http.Request) {
+	token, err := middleware.AuthenticationToken(request.Context())
+	if err != nil {
+		log.Println(err)
+		respondUnauthorized(writer)
+		return
+	}
+
+	err = s.customersSvc.RevokeToken(request.Context(), token)
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(writer, map[string]interface{}{"status": "ok"})
+}
+
+// handleCustomerGetSessions - gets information about active tokens.
+func (s *Server) handleCustomerGetSessions(writer http.ResponseWriter, request *http.Request) {
+	id, err := middleware.Authentication(request.Context())
+	if err != nil {
+		log.Println(err)
+		respondUnauthorized(writer)
+		return
+	}
+
+	token, err := middleware.AuthenticationToken(request.Context())
+	if err != nil {
+		log.Println(err)
+		respondUnauthorized(writer)
+		return
+	}
+
+	items, err := s.customersSvc.Sessions(request.Context(), id, token)
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(writer, items)
+}
+
+// handleCustomerRevokeSessions - revokes all tokens (log out everywhere).
+func (s *Server) handleCustomerRevokeSessions(writer http.ResponseWriter, request *http.Request) {
+	id, err := middleware.Authentication(request.Context())
+	if err != nil {
+		log.Println(err)
+		respondUnauthorized(writer)
+		return
+	}
+
+	err = s.customersSvc.RevokeTokens(request.Context(), id)
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(writer, map[string]interface{}{"status": "ok"})
+}
+
+// handleCustomerChangePassword - changes the password of customer.
+func (s *Server) handleCustomerChangePassword(writer http.ResponseWriter, request *http.Request) {
+	id, err := middleware.Authentication(request.Context())
+	if err != nil {
+		log.Println(err)
+		respondUnauthorized(writer)
+		return
+	}
+
+	var item *types.PasswordChange
+	if err = json.NewDecoder(request.Body).Decode(&item); err != nil || item == nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	err = s.customersSvc.ChangePassword(request.Context(), id, item)
+	var validationErr *types.ValidationError
+	if errors.As(err, &validationErr) {
+		log.Println(err)
+		respondValidationError(writer, validationErr)
+		return
+	}
+	if errors.Is(err, customers.ErrInvalidPassword) {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(writer, map[string]interface{}{"status": "ok"})
+}
+
+// handleCustomerRequestPasswordReset - sends the code to reset the password.
+func (s *Server) handleCustomerRequestPasswordReset(writer http.ResponseWriter, request *http.Request) {
+	var item *types.PasswordResetRequest
+
+	if err := json.NewDecoder(request.Body).Decode(&item); err != nil || item == nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	err := s.customersSvc.RequestPasswordReset(request.Context(), item.Phone)
+	if errors.Is(err, customers.ErrTooManyRequests) {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+		return
+	}
 	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(writer, map[string]interface{}{"status": "ok"})
+}
+
+// handleCustomerResetPassword - sets a new password by the code.
+func (s *Server) handleCustomerResetPassword(writer http.ResponseWriter, request *http.Request) {
+	var item *types.PasswordReset
+
+	if err := json.NewDecoder(request.Body).Decode(&item); err != nil || item == nil {
 		log.Println(err)
 		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		return
 	}
 
-	token, err := s.customersSvc.Token(request.Context(), item.Login, item.Password)
+	err := s.customersSvc.ResetPassword(request.Context(), item)
+	var validationErr *types.ValidationError
+	if errors.As(err, &validationErr) {
+		log.Println(err)
+		respondValidationError(writer, validationErr)
+		return
+	}
+	if errors.Is(err, customers.ErrTooManyRequests) {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+		return
+	}
+	if errors.Is(err, customers.ErrInvalidCode) {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
 	if err != nil {
 		log.Println(err)
 		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
 
-	respondJSON(writer, &types.Token{Token: token})
+	respondJSON(writer, map[string]interface{}{"status": "ok"})
 }
 
-// handleCustomerGetProducts - gets information about products.
+// handleCustomerGetProducts - searches the products by query parameters.
 func (s *Server) handleCustomerGetProducts(writer http.ResponseWriter, request *http.Request) {
-	items, err := s.customersSvc.Products(request.Context())
+	filter, err := productFilter(request.URL.Query())
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	items, page, err := s.customersSvc.Products(request.Context(), filter)
+	respondProducts(writer, items, page, err)
+}
+
+// handleCustomerGetProductByBarcode - finds the product by barcode.
+func (s *Server) handleCustomerGetProductByBarcode(writer http.ResponseWriter, request *http.Request) {
+	item, err := s.customersSvc.ProductByBarcode(request.Context(), mux.Vars(request)["barcode"])
+	if errors.Is(err, customers.ErrNotFound) {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(writer, item)
+}
+
+// handleCustomerGetCategories - gets the categories of products.
+func (s *Server) handleCustomerGetCategories(writer http.ResponseWriter, request *http.Request) {
+	items, err := s.customersSvc.Categories(request.Context())
 	if err != nil {
 		log.Println(err)
 		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
@@ -67,7 +314,7 @@ func (s *Server) handleCustomerGetPurchases(writer http.ResponseWriter, request
 	id, err := middleware.Authentication(request.Context())
 	if err != nil {
 		log.Println(err)
-		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		respondUnauthorized(writer)
 		return
 	}
 
@@ -81,30 +328,169 @@ func (s *Server) handleCustomerGetPurchases(writer http.ResponseWriter, request
 	respondJSON(writer, items)
 }
 
-// handleCustomerMakePurchase - makes a purchase.
-func (s *Server) handleCustomerMakePurchase(writer http.ResponseWriter, request *http.Request) {
-
-	var item *types.Sales
+// handleCustomerGetReceipt - gets the printable receipt of own purchase.
+func (s *Server) handleCustomerGetReceipt(writer http.ResponseWriter, request *http.Request) {
+	id, err := middleware.Authentication(request.Context())
+	if err != nil {
+		log.Println(err)
+		respondUnauthorized(writer)
+		return
+	}
 
-	if err := json.NewDecoder(request.Body).Decode(&item); err != nil {
+	saleID, err := strconv.ParseInt(mux.Vars(request)["id"], 10, 64)
+	if err != nil {
 		log.Println(err)
 		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		return
 	}
 
-	purchase, err := s.customersSvc.MakePurchase(request.Context(), item)
+	receipt, err := s.receiptsSvc.Receipt(request.Context(), saleID, 0, id)
+	s.respondReceipt(writer, request, receipt, err)
+}
+
+// handleCustomerGetCart - gets the cart with current prices.
+func (s *Server) handleCustomerGetCart(writer http.ResponseWriter, request *http.Request) {
+	id, err := middleware.Authentication(request.Context())
+	if err != nil {
+		log.Println(err)
+		respondUnauthorized(writer)
+		return
+	}
+
+	cart, err := s.customersSvc.Cart(request.Context(), id)
 	if err != nil {
 		log.Println(err)
 		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
 
-	respondJSON(writer, purchase)
+	respondJSON(writer, cart)
+}
+
+// handleCustomerAddToCart - adds the product to the cart.
+func (s *Server) handleCustomerAddToCart(writer http.ResponseWriter, request *http.Request) {
+	id, err := middleware.Authentication(request.Context())
+	if err != nil {
+		log.Println(err)
+		respondUnauthorized(writer)
+		return
+	}
+
+	var item *types.CartItem
+	if err = json.NewDecoder(request.Body).Decode(&item); err != nil || item == nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	err = s.customersSvc.AddToCart(request.Context(), id, item)
+	s.respondCart(writer, request, id, err)
+}
+
+// handleCustomerChangeCartItem - changes quantity of the product in the cart.
+func (s *Server) handleCustomerChangeCartItem(writer http.ResponseWriter, request *http.Request) {
+	id, err := middleware.Authentication(request.Context())
+	if err != nil {
+		log.Println(err)
+		respondUnauthorized(writer)
+		return
+	}
+
+	productID, err := strconv.ParseInt(mux.Vars(request)["productID"], 10, 64)
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	var item *types.CartItem
+	if err = json.NewDecoder(request.Body).Decode(&item); err != nil || item == nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	err = s.customersSvc.ChangeCartItem(request.Context(), id, productID, item.Qty)
+	s.respondCart(writer, request, id, err)
+}
+
+// handleCustomerRemoveFromCart - removes the product from the cart.
+func (s *Server) handleCustomerRemoveFromCart(writer http.ResponseWriter, request *http.Request) {
+	id, err := middleware.Authentication(request.Context())
+	if err != nil {
+		log.Println(err)
+		respondUnauthorized(writer)
+		return
+	}
+
+	productID, err := strconv.ParseInt(mux.Vars(request)["productID"], 10, 64)
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	err = s.customersSvc.RemoveFromCart(request.Context(), id, productID)
+	s.respondCart(writer, request, id, err)
+}
 
+// handleCustomerCheckout - buys the products of the cart.
+func (s *Server) handleCustomerCheckout(writer http.ResponseWriter, request *http.Request) {
+	id, err := middleware.Authentication(request.Context())
+	if err != nil {
+		log.Println(err)
+		respondUnauthorized(writer)
+		return
+	}
 
+	// the body with promo code and currency is optional.
+	item := &types.Checkout{}
+	if err = json.NewDecoder(request.Body).Decode(item); err != nil && !errors.Is(err, io.EOF) {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
 
+	receipt, err := s.customersSvc.Checkout(request.Context(), id, item)
+	var validationErr *types.ValidationError
+	if errors.As(err, &validationErr) {
+		log.Println(err)
+		respondValidationError(writer, validationErr)
+		return
+	}
+	var stockErr *sales.InsufficientStockError
+	if errors.As(err, &stockErr) {
+		log.Println(err)
+		respondInsufficientStock(writer, stockErr)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
 
+	respondJSON(writer, receipt)
+}
 
+// respondCart - response with the cart after its change or with the error of the change.
+func (s *Server) respondCart(writer http.ResponseWriter, request *http.Request, id int64, err error) {
+	var validationErr *types.ValidationError
+	if errors.As(err, &validationErr) {
+		log.Println(err)
+		respondValidationError(writer, validationErr)
+		return
+	}
+	if errors.Is(err, customers.ErrNotFound) {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
 
-	
-}
\ No newline at end of file
+	s.handleCustomerGetCart(writer, request)
+}
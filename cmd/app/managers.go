@@ -2,11 +2,20 @@ package app
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/SardorMS/CRUD/cmd/app/middleware"
+	"github.com/SardorMS/CRUD/pkg/logins"
+	"github.com/SardorMS/CRUD/pkg/managers"
+	"github.com/SardorMS/CRUD/pkg/sales"
 	"github.com/SardorMS/CRUD/pkg/types"
 	"github.com/gorilla/mux"
 )
@@ -14,309 +23,1635 @@ import (
 // handleManagerRegistration - registrate managers.
 func (s *Server) handleManagerRegistration(writer http.ResponseWriter, request *http.Request) {
 
-	var item *types.ManagerRegister
-
-	id, err := middleware.Authentication(request.Context())
+	registrarID, err := middleware.Authentication(request.Context())
 	if err != nil {
+		log.Println(err)
+		respondUnauthorized(writer)
+		return
+	}
+
+	item := &types.ManagerRegister{}
+	if err = json.NewDecoder(request.Body).Decode(item); err != nil {
 		log.Println(err)
 		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		return
 	}
 
-	if id == 0 {
+	items := &types.Managers{
+		ID:    item.ID,
+		Name:  item.Name,
+		Phone: item.Phone,
+		Roles: item.Roles,
+	}
+
+	code, err := s.managersSvc.Register(request.Context(), registrarID, items)
+	if errors.Is(err, managers.ErrRoleForbidden) {
 		log.Println(err)
 		http.Error(writer, http.StatusText(http.StatusForbidden), http.StatusForbidden)
 		return
 	}
-
-	if err = json.NewDecoder(request.Body).Decode(&item); err != nil {
+	if errors.Is(err, managers.ErrPhoneUsed) {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusConflict), http.StatusConflict)
+		return
+	}
+	if errors.Is(err, managers.ErrRoleNotFound) {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
 		log.Println(err)
 		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
 
-	if admin := s.managersSvc.IsAdmin(request.Context(), id); !admin {
+	respondJSON(writer, code)
+}
+
+// handleManagerSetupPassword - sets up the password of registred manager by one-time code.
+func (s *Server) handleManagerSetupPassword(writer http.ResponseWriter, request *http.Request) {
+	var item *types.PasswordSetup
+
+	if err := json.NewDecoder(request.Body).Decode(&item); err != nil || item == nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	token, err := s.managersSvc.SetupPassword(request.Context(), item, clientInfo(request))
+	var validationErr *types.ValidationError
+	if errors.As(err, &validationErr) {
+		log.Println(err)
+		respondValidationError(writer, validationErr)
+		return
+	}
+	if errors.Is(err, managers.ErrInvalidCode) {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	if err != nil {
 		log.Println(err)
 		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
 
-	items := &types.Managers{
-		ID:    item.ID,
-		Name:  item.Name,
-		Phone: item.Phone,
+	respondJSON(writer, token)
+}
+
+// handleManagerChangePassword - changes the password of manager.
+func (s *Server) handleManagerChangePassword(writer http.ResponseWriter, request *http.Request) {
+	id, err := middleware.Authentication(request.Context())
+	if err != nil {
+		log.Println(err)
+		respondUnauthorized(writer)
+		return
 	}
 
-	for _, role := range item.Roles {
-		if role == "ADMIN" {
-			items.IsAdmin = true
-			break
-		}
+	var item *types.PasswordChange
+	if err = json.NewDecoder(request.Body).Decode(&item); err != nil || item == nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	err = s.managersSvc.ChangePassword(request.Context(), id, item)
+	var validationErr *types.ValidationError
+	if errors.As(err, &validationErr) {
+		log.Println(err)
+		respondValidationError(writer, validationErr)
+		return
+	}
+	if errors.Is(err, managers.ErrInvalidPassword) {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
 	}
-	token, err := s.managersSvc.Register(request.Context(), items)
 	if err != nil {
 		log.Println(err)
 		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
 
-	respondJSON(writer, map[string]interface{}{"token": token})
+	respondJSON(writer, map[string]interface{}{"status": "ok"})
 }
 
 // handleManagerGetToken - generate token for registred managers.
 func (s *Server) handleManagerGetToken(writer http.ResponseWriter, request *http.Request) {
-	var item *types.Managers
+	item := &types.Managers{}
 
-	if err := json.NewDecoder(request.Body).Decode(&item); err != nil {
+	if err := json.NewDecoder(request.Body).Decode(item); err != nil {
 		log.Println(err)
-		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		return
 	}
 
-	token, err := s.managersSvc.Token(request.Context(), item.Phone, item.Password)
+	token, err := s.managersSvc.Token(request.Context(), item.Phone, item.Password, clientInfo(request))
+	var lockedErr *logins.LockedError
+	if errors.As(err, &lockedErr) {
+		log.Println(err)
+		respondLocked(writer, lockedErr)
+		return
+	}
+	if errors.Is(err, managers.ErrNoSuchUser) || errors.Is(err, managers.ErrInvalidPassword) {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
 	if err != nil {
 		log.Println(err)
-		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
 
-	respondJSON(writer, map[string]interface{}{"token": token})
+	respondJSON(writer, token)
 }
 
-// handleManagerGetSales - gets information about sales.
-func (s *Server) handleManagerGetSales(writer http.ResponseWriter, request *http.Request) {
+// handleManagerRefreshToken - exchanges refresh token for a new token.
+func (s *Server) handleManagerRefreshToken(writer http.ResponseWriter, request *http.Request) {
+	var item *types.Refresh
 
-	id, err := middleware.Authentication(request.Context())
-	if err != nil {
+	if err := json.NewDecoder(request.Body).Decode(&item); err != nil || item == nil {
 		log.Println(err)
 		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		return
 	}
 
-	if id == 0 {
+	token, err := s.managersSvc.RefreshToken(request.Context(), item.RefreshToken, clientInfo(request))
+	if errors.Is(err, managers.ErrTokenNotFound) || errors.Is(err, managers.ErrTokenExpired) {
 		log.Println(err)
-		http.Error(writer, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		http.Error(writer, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 		return
 	}
-
-	total, err := s.managersSvc.GetSales(request.Context(), id)
 	if err != nil {
 		log.Println(err)
-		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
 
-	respondJSON(writer, map[string]interface{}{"manager_id": id, "total": total})
+	respondJSON(writer, token)
 }
 
-// handleManagerMakeSale - makes sale.
-func (s *Server) handleManagerMakeSale(writer http.ResponseWriter, request *http.Request) {
+// handleManagerRevokeToken - revokes the current token (log out).
+func (s *Server) handleManagerRevokeToken(writer http.ResponseWriter, request *http.Request) {
+	token, err := middleware.AuthenticationToken(request.Context())
+	if err != nil {
+		log.Println(err)
+		respondUnauthorized(writer)
+		return
+	}
 
-	id, err := middleware.Authentication(request.Context())
+	err = s.managersSvc.RevokeToken(request.Context(), token)
 	if err != nil {
 		log.Println(err)
-		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
 
-	if id == 0 {
+	respondJSON(writer, map[string]interface{}{"status": "ok"})
+}
+
+// handleManagerGetSessions - gets information about active tokens.
+func (s *Server) handleManagerGetSessions(writer http.ResponseWriter, request *http.Request) {
+	id, err := middleware.Authentication(request.Context())
+	if err != nil {
 		log.Println(err)
-		http.Error(writer, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		respondUnauthorized(writer)
 		return
 	}
 
-	sale := &types.Sale{}
-	sale.ManagerID = id
+	token, err := middleware.AuthenticationToken(request.Context())
+	if err != nil {
+		log.Println(err)
+		respondUnauthorized(writer)
+		return
+	}
 
-	if err := json.NewDecoder(request.Body).Decode(&sale); err != nil {
+	items, err := s.managersSvc.Sessions(request.Context(), id, token)
+	if err != nil {
 		log.Println(err)
 		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
 
-	sale, err = s.managersSvc.MakeSales(request.Context(), sale)
+	respondJSON(writer, items)
+}
+
+// handleManagerRevokeSessions - revokes all tokens (log out everywhere).
+func (s *Server) handleManagerRevokeSessions(writer http.ResponseWriter, request *http.Request) {
+	id, err := middleware.Authentication(request.Context())
 	if err != nil {
 		log.Println(err)
-		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		respondUnauthorized(writer)
 		return
 	}
 
-	respondJSON(writer, sale)
+	err = s.managersSvc.RevokeTokens(request.Context(), id)
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(writer, map[string]interface{}{"status": "ok"})
 }
 
-// handleManagerGetProducts - gets information about products.
-func (s *Server) handleManagerGetProducts(writer http.ResponseWriter, request *http.Request) {
+// handleManagerRevokeManagerSessions - revokes all tokens of any manager (admin).
+func (s *Server) handleManagerRevokeManagerSessions(writer http.ResponseWriter, request *http.Request) {
+	idParam, ok := mux.Vars(request)["id"]
+	if !ok {
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
 
-	items, err := s.managersSvc.Products(request.Context())
+	managerID, err := strconv.ParseInt(idParam, 10, 64)
 	if err != nil {
 		log.Println(err)
 		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		return
 	}
 
-	respondJSON(writer, items)
+	err = s.managersSvc.RevokeTokens(request.Context(), managerID)
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(writer, map[string]interface{}{"manager_id": managerID, "status": "ok"})
 }
 
-// handleManagerChangeProduct - change product information.
-func (s *Server) handleManagerChangeProduct(writer http.ResponseWriter, request *http.Request) {
-	id, err := middleware.Authentication(request.Context())
+// handleManagerGetRoles - gets information about roles.
+func (s *Server) handleManagerGetRoles(writer http.ResponseWriter, request *http.Request) {
+	items, err := s.managersSvc.Roles(request.Context())
 	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(writer, items)
+}
+
+// handleManagerGetPermissions - gets all known permissions.
+func (s *Server) handleManagerGetPermissions(writer http.ResponseWriter, request *http.Request) {
+	respondJSON(writer, managers.Permissions)
+}
+
+// handleManagerCreateRole - creates a new role.
+func (s *Server) handleManagerCreateRole(writer http.ResponseWriter, request *http.Request) {
+	role := &types.Role{}
+
+	if err := json.NewDecoder(request.Body).Decode(role); err != nil {
 		log.Println(err)
 		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		return
 	}
 
-	if id == 0 {
+	role, err := s.managersSvc.CreateRole(request.Context(), role)
+	if err != nil {
 		log.Println(err)
-		http.Error(writer, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		respondRoleError(writer, err)
 		return
 	}
 
-	product := &types.Products{}
+	respondJSON(writer, role)
+}
+
+// handleManagerChangeRole - changes description and permissions of the role.
+func (s *Server) handleManagerChangeRole(writer http.ResponseWriter, request *http.Request) {
+	role := &types.Role{}
 
-	if err := json.NewDecoder(request.Body).Decode(&product); err != nil {
+	if err := json.NewDecoder(request.Body).Decode(role); err != nil {
 		log.Println(err)
-		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		return
 	}
+	role.Name = mux.Vars(request)["name"]
 
-	product, err = s.managersSvc.ChangeProduct(request.Context(), product)
+	role, err := s.managersSvc.ChangeRole(request.Context(), role)
 	if err != nil {
 		log.Println(err)
-		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		respondRoleError(writer, err)
 		return
 	}
 
-	respondJSON(writer, product)
+	respondJSON(writer, role)
 }
 
-// handleManagerRemoveProductByID - removes product information by ID (manager).
-func (s *Server) handleManagerRemoveProductByID(writer http.ResponseWriter, request *http.Request) {
-	id, err := middleware.Authentication(request.Context())
+// handleManagerRemoveRole - removes the role.
+func (s *Server) handleManagerRemoveRole(writer http.ResponseWriter, request *http.Request) {
+	name := mux.Vars(request)["name"]
+
+	err := s.managersSvc.RemoveRole(request.Context(), name)
+	if err != nil {
+		log.Println(err)
+		respondRoleError(writer, err)
+		return
+	}
+
+	respondJSON(writer, map[string]interface{}{"name": name, "status": "ok"})
+}
+
+// handleManagerSetRoles - assigns roles to manager.
+func (s *Server) handleManagerSetRoles(writer http.ResponseWriter, request *http.Request) {
+	idParam, ok := mux.Vars(request)["id"]
+	if !ok {
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	managerID, err := strconv.ParseInt(idParam, 10, 64)
 	if err != nil {
 		log.Println(err)
 		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		return
 	}
 
-	if id == 0 {
+	item := &types.ManagerRoles{}
+	if err = json.NewDecoder(request.Body).Decode(item); err != nil {
 		log.Println(err)
-		http.Error(writer, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	err = s.managersSvc.SetManagerRoles(request.Context(), managerID, item.Roles)
+	if errors.Is(err, managers.ErrNoSuchUser) {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		respondRoleError(writer, err)
 		return
 	}
 
+	respondJSON(writer, map[string]interface{}{"manager_id": managerID, "roles": item.Roles})
+}
+
+// respondRoleError - response for errors of roles management.
+func respondRoleError(writer http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, managers.ErrRoleNotFound):
+		http.Error(writer, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	case errors.Is(err, managers.ErrRoleExists), errors.Is(err, managers.ErrLastAdmin), errors.Is(err, managers.ErrDefaultRole):
+		http.Error(writer, http.StatusText(http.StatusConflict), http.StatusConflict)
+	case errors.Is(err, managers.ErrRoleProtected):
+		http.Error(writer, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	case errors.Is(err, managers.ErrInvalidRole), errors.Is(err, managers.ErrUnknownPermission):
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+	default:
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+// handleManagerUnlockCustomer - removes the lock of customer logins (admin).
+func (s *Server) handleManagerUnlockCustomer(writer http.ResponseWriter, request *http.Request) {
 	idParam, ok := mux.Vars(request)["id"]
 	if !ok {
 		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		return
 	}
 
-	productID, err := strconv.ParseInt(idParam, 10, 64)
+	customerID, err := strconv.ParseInt(idParam, 10, 64)
 	if err != nil {
 		log.Println(err)
 		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		return
 	}
 
-	item, err := s.managersSvc.RemoveProductByID(request.Context(), productID)
+	err = s.managersSvc.UnlockCustomer(request.Context(), customerID)
+	if errors.Is(err, managers.ErrNotFound) {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		log.Println(err)
 		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
 
-	respondJSON(writer, item)
+	respondJSON(writer, map[string]interface{}{"customer_id": customerID, "status": "ok"})
 }
 
-// handleManagerGetCustomers - gets information about customers.
-func (s *Server) handleManagerGetCustomers(writer http.ResponseWriter, request *http.Request) {
-	id, err := middleware.Authentication(request.Context())
+// handleManagerUnlockManager - removes the lock of manager logins (admin).
+func (s *Server) handleManagerUnlockManager(writer http.ResponseWriter, request *http.Request) {
+	idParam, ok := mux.Vars(request)["id"]
+	if !ok {
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	managerID, err := strconv.ParseInt(idParam, 10, 64)
 	if err != nil {
 		log.Println(err)
 		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		return
 	}
 
-	if id == 0 {
+	err = s.managersSvc.UnlockManager(request.Context(), managerID)
+	if errors.Is(err, managers.ErrNotFound) {
 		log.Println(err)
-		http.Error(writer, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		http.Error(writer, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 		return
 	}
-	item, err := s.managersSvc.GetCustomer(request.Context())
 	if err != nil {
 		log.Println(err)
 		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
 
-	respondJSON(writer, item)
+	respondJSON(writer, map[string]interface{}{"manager_id": managerID, "status": "ok"})
+}
+
+// handleManagerGetLogins - gets the login history (admin).
+func (s *Server) handleManagerGetLogins(writer http.ResponseWriter, request *http.Request) {
+	query := request.URL.Query()
+
+	filter := &types.LoginFilter{
+		Kind:  query.Get("kind"),
+		Phone: query.Get("phone"),
+		IP:    query.Get("ip"),
+		Limit: 100,
+	}
+
+	if value := query.Get("success"); value != "" {
+		success, err := strconv.ParseBool(value)
+		if err != nil {
+			log.Println(err)
+			http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		filter.Success = &success
+	}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > 500 {
+			log.Println(err)
+			http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		filter.Limit = limit
+	}
+
+	items, err := s.managersSvc.LoginHistory(request.Context(), filter)
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(writer, items)
 }
 
-// handleManagerChangeCustomer - changes information about customer.
-func (s *Server) handleManagerChangeCustomer(writer http.ResponseWriter, request *http.Request) {
+// handleManagerGetSales - searches the sales, managers without sales:read:all see only their own.
+func (s *Server) handleManagerGetSales(writer http.ResponseWriter, request *http.Request) {
 	id, err := middleware.Authentication(request.Context())
 	if err != nil {
 		log.Println(err)
-		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		respondUnauthorized(writer)
 		return
 	}
 
-	if id == 0 {
+	filter, err := saleFilter(request.URL.Query())
+	if err != nil {
 		log.Println(err)
-		http.Error(writer, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		return
 	}
 
-	customer := &types.Customers{}
-	if err := json.NewDecoder(request.Body).Decode(&customer); err != nil {
+	all, err := middleware.HasPermission(request.Context(), managers.PermSalesReadAll)
+	if err != nil {
 		log.Println(err)
 		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
+	if !all {
+		filter.ManagerID = id
+	}
 
-	customer, err = s.managersSvc.ChangeCustomer(request.Context(), customer)
-	if err != nil {
+	items, err := s.managersSvc.ListSales(request.Context(), filter)
+	if errors.Is(err, managers.ErrInvalidSort) {
 		log.Println(err)
 		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		return
 	}
-	respondJSON(writer, customer)
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(writer, items)
 }
 
-// handleManagerRemoveCustomerByID - remove information  about customer by id (manager).
-func (s *Server) handleManagerRemoveCustomerByID(writer http.ResponseWriter, request *http.Request) {
+// handleManagerGetSaleByID - gets the sale with positions, managers without sales:read:all
+// see only their own.
+func (s *Server) handleManagerGetSaleByID(writer http.ResponseWriter, request *http.Request) {
 	id, err := middleware.Authentication(request.Context())
+	if err != nil {
+		log.Println(err)
+		respondUnauthorized(writer)
+		return
+	}
+
+	saleID, err := strconv.ParseInt(mux.Vars(request)["id"], 10, 64)
 	if err != nil {
 		log.Println(err)
 		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		return
 	}
 
-	if id == 0 {
+	managerID, err := salesOwner(request, id)
+	if err != nil {
 		log.Println(err)
 		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
 
-	idParam, ok := mux.Vars(request)["id"]
-	if !ok {
-		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+	item, err := s.managersSvc.SaleByID(request.Context(), saleID, managerID)
+	if errors.Is(err, managers.ErrNotFound) {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
 
-	customerID, err := strconv.ParseInt(idParam, 10, 64)
+	respondJSON(writer, item)
+}
+
+// handleManagerGetReceipt - gets the printable receipt of sale.
+func (s *Server) handleManagerGetReceipt(writer http.ResponseWriter, request *http.Request) {
+	id, err := middleware.Authentication(request.Context())
+	if err != nil {
+		log.Println(err)
+		respondUnauthorized(writer)
+		return
+	}
+
+	saleID, err := strconv.ParseInt(mux.Vars(request)["id"], 10, 64)
 	if err != nil {
 		log.Println(err)
 		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		return
 	}
 
-	item, err := s.managersSvc.RemoveCustomerByID(request.Context(), customerID)
+	all, err := middleware.HasPermission(request.Context(), managers.PermSalesReadAll)
 	if err != nil {
 		log.Println(err)
 		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
 
-	respondJSON(writer, item)
+	managerID := id
+	if all {
+		managerID = 0
+	}
+
+	receipt, err := s.receiptsSvc.Receipt(request.Context(), saleID, managerID, 0)
+	s.respondReceipt(writer, request, receipt, err)
+}
+
+// saleFilter - reads the conditions of sales search from query parameters.
+func saleFilter(query url.Values) (*types.SaleFilter, error) {
+	filter := &types.SaleFilter{
+		Status:   query.Get("status"),
+		Currency: strings.ToUpper(query.Get("currency")),
+		Sort:     query.Get("sort"),
+		Limit:    50,
+	}
+
+	var err error
+	if filter.From, err = queryTime(query, "from", false); err != nil {
+		return nil, err
+	}
+	if filter.To, err = queryTime(query, "to", true); err != nil {
+		return nil, err
+	}
+
+	ids := map[string]*int64{
+		"customer_id": &filter.CustomerID,
+		"product_id":  &filter.ProductID,
+		"manager_id":  &filter.ManagerID,
+	}
+	for name, id := range ids {
+		if value := query.Get(name); value != "" {
+			if *id, err = strconv.ParseInt(value, 10, 64); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	totals := map[string]**int64{
+		"min_total": &filter.MinTotal,
+		"max_total": &filter.MaxTotal,
+	}
+	for name, total := range totals {
+		if value := query.Get(name); value != "" {
+			number, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			*total = &number
+		}
+	}
+
+	if value := query.Get("limit"); value != "" {
+		filter.Limit, err = strconv.Atoi(value)
+		if err != nil || filter.Limit <= 0 || filter.Limit > 500 {
+			return nil, fmt.Errorf("invalid limit: %q", value)
+		}
+	}
+
+	if value := query.Get("offset"); value != "" {
+		filter.Offset, err = strconv.Atoi(value)
+		if err != nil || filter.Offset < 0 {
+			return nil, fmt.Errorf("invalid offset: %q", value)
+		}
+	}
+
+	return filter, nil
+}
+
+// queryTime - reads the time in RFC 3339 or the date (2006-01-02) from query parameter,
+// the date is the start of the day or, if end is true, the start of the next day.
+func queryTime(query url.Values, name string, end bool) (*time.Time, error) {
+	value := query.Get(name)
+	if value == "" {
+		return nil, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return nil, err
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return &t, nil
+}
+
+// handleManagerGetSalesTotal - gets the total of sales of the manager without refunds.
+func (s *Server) handleManagerGetSalesTotal(writer http.ResponseWriter, request *http.Request) {
+
+	id, err := middleware.Authentication(request.Context())
+	if err != nil {
+		log.Println(err)
+		respondUnauthorized(writer)
+		return
+	}
+
+	total, tax, err := s.managersSvc.GetSales(request.Context(), id)
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(writer, map[string]interface{}{"manager_id": id, "total": total, "tax": tax})
+}
+
+// handleManagerMakeSale - makes sale.
+func (s *Server) handleManagerMakeSale(writer http.ResponseWriter, request *http.Request) {
+
+	id, err := middleware.Authentication(request.Context())
+	if err != nil {
+		log.Println(err)
+		respondUnauthorized(writer)
+		return
+	}
+
+	sale := &types.Sale{}
+	if err := json.NewDecoder(request.Body).Decode(sale); err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	sale.ManagerID = id
+
+	override, err := middleware.HasPermission(request.Context(), managers.PermSalesOverride)
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	sale, err = s.managersSvc.MakeSales(request.Context(), sale, override)
+	if err != nil {
+		respondSaleError(writer, err)
+		return
+	}
+
+	respondJSON(writer, sale)
+}
+
+// handleManagerMakeReturn - returns products of the sale and refunds their price.
+func (s *Server) handleManagerMakeReturn(writer http.ResponseWriter, request *http.Request) {
+	id, err := middleware.Authentication(request.Context())
+	if err != nil {
+		log.Println(err)
+		respondUnauthorized(writer)
+		return
+	}
+
+	saleID, err := strconv.ParseInt(mux.Vars(request)["id"], 10, 64)
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	item := &types.SaleReturn{}
+	if err = json.NewDecoder(request.Body).Decode(item); err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	item.SaleID = saleID
+	item.ManagerID = id
+
+	ownerID, err := salesOwner(request, id)
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	item, err = s.managersSvc.MakeReturn(request.Context(), item, ownerID)
+	if err != nil {
+		respondSaleError(writer, err)
+		return
+	}
+
+	respondJSON(writer, item)
+}
+
+// handleManagerAddPayments - pays the pending sale by one or several methods.
+func (s *Server) handleManagerAddPayments(writer http.ResponseWriter, request *http.Request) {
+	id, err := middleware.Authentication(request.Context())
+	if err != nil {
+		log.Println(err)
+		respondUnauthorized(writer)
+		return
+	}
+
+	saleID, err := strconv.ParseInt(mux.Vars(request)["id"], 10, 64)
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	var payments []*types.Payment
+	if err = json.NewDecoder(request.Body).Decode(&payments); err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	ownerID, err := salesOwner(request, id)
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	sale, err := s.managersSvc.AddPayments(request.Context(), saleID, payments, id, ownerID)
+	if err != nil {
+		respondSaleError(writer, err)
+		return
+	}
+
+	respondJSON(writer, sale)
+}
+
+// handleManagerVoidSale - cancels pending or paid sale.
+func (s *Server) handleManagerVoidSale(writer http.ResponseWriter, request *http.Request) {
+	id, err := middleware.Authentication(request.Context())
+	if err != nil {
+		log.Println(err)
+		respondUnauthorized(writer)
+		return
+	}
+
+	saleID, err := strconv.ParseInt(mux.Vars(request)["id"], 10, 64)
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	ownerID, err := salesOwner(request, id)
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	sale, err := s.managersSvc.VoidSale(request.Context(), saleID, id, ownerID)
+	if err != nil {
+		respondSaleError(writer, err)
+		return
+	}
+
+	respondJSON(writer, sale)
+}
+
+// salesOwner - id of the manager whose sales are available to the manager: own sales,
+// or all sales (0) with sales:read:all permission.
+func salesOwner(request *http.Request, id int64) (int64, error) {
+	all, err := middleware.HasPermission(request.Context(), managers.PermSalesReadAll)
+	if err != nil {
+		return 0, err
+	}
+	if all {
+		return 0, nil
+	}
+	return id, nil
+}
+
+// respondSaleError - response with the error of sale, its payment or return.
+func respondSaleError(writer http.ResponseWriter, err error) {
+	log.Println(err)
+
+	var validationErr *types.ValidationError
+	var stockErr *sales.InsufficientStockError
+	switch {
+	case errors.As(err, &validationErr):
+		respondValidationError(writer, validationErr)
+	case errors.As(err, &stockErr):
+		respondInsufficientStock(writer, stockErr)
+	case errors.Is(err, sales.ErrNotFound):
+		http.Error(writer, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	case errors.Is(err, sales.ErrInvalidStatus):
+		http.Error(writer, http.StatusText(http.StatusConflict), http.StatusConflict)
+	default:
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+// handleManagerGetProducts - searches the products by query parameters.
+func (s *Server) handleManagerGetProducts(writer http.ResponseWriter, request *http.Request) {
+
+	filter, err := productFilter(request.URL.Query())
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	filter.Archived = includeArchived(request.URL.Query())
+	filter.Active, err = activeFilter(request.URL.Query())
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	items, page, err := s.managersSvc.Products(request.Context(), filter)
+	respondProducts(writer, items, page, err)
+}
+
+// handleManagerGetProductByBarcode - finds the product by scanned barcode.
+func (s *Server) handleManagerGetProductByBarcode(writer http.ResponseWriter, request *http.Request) {
+	item, err := s.managersSvc.ProductByBarcode(request.Context(), mux.Vars(request)["barcode"])
+	respondProduct(writer, item, err)
+}
+
+// handleManagerGetProductByID - gets the product by id, archived too.
+func (s *Server) handleManagerGetProductByID(writer http.ResponseWriter, request *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(request)["id"], 10, 64)
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	item, err := s.managersSvc.ProductByID(request.Context(), id)
+	respondProduct(writer, item, err)
+}
+
+// handleManagerCreateProduct - creates the product, it's active if active isn't given.
+func (s *Server) handleManagerCreateProduct(writer http.ResponseWriter, request *http.Request) {
+	item := &types.Products{Active: true}
+	if err := json.NewDecoder(request.Body).Decode(item); err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	item, err := s.managersSvc.CreateProduct(request.Context(), item)
+	respondProduct(writer, item, err)
+}
+
+// handleManagerReplaceProduct - replaces all information about the product, omitted fields
+// get default values.
+func (s *Server) handleManagerReplaceProduct(writer http.ResponseWriter, request *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(request)["id"], 10, 64)
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	version, ok := ifMatch(writer, request)
+	if !ok {
+		return
+	}
+
+	item := &types.Products{Active: true}
+	if err = json.NewDecoder(request.Body).Decode(item); err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	item.ID = id
+
+	item, err = s.managersSvc.ReplaceProduct(request.Context(), item, version)
+	respondProduct(writer, item, err)
+}
+
+// handleManagerPatchProduct - changes the fields of product given in JSON Merge Patch.
+func (s *Server) handleManagerPatchProduct(writer http.ResponseWriter, request *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(request)["id"], 10, 64)
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	version, ok := ifMatch(writer, request)
+	if !ok {
+		return
+	}
+
+	changes, ok := readMergePatch(writer, request)
+	if !ok {
+		return
+	}
+
+	item, err := s.managersSvc.PatchProduct(request.Context(), id, version, changes)
+	respondProduct(writer, item, err)
+}
+
+// handleManagerAdjustStock - adds quantity to the stock of product or writes it off.
+func (s *Server) handleManagerAdjustStock(writer http.ResponseWriter, request *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(request)["id"], 10, 64)
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	item := &types.StockAdjustment{}
+	if err = json.NewDecoder(request.Body).Decode(item); err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	product, err := s.managersSvc.AdjustStock(request.Context(), id, item.Qty)
+	respondProduct(writer, product, err)
+}
+
+// respondProduct - response with the product or with the error of saving.
+func respondProduct(writer http.ResponseWriter, item *types.Products, err error) {
+	var validationErr *types.ValidationError
+	if errors.As(err, &validationErr) {
+		log.Println(err)
+		respondValidationError(writer, validationErr)
+		return
+	}
+	if errors.Is(err, managers.ErrInvalidPatch) {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, managers.ErrNotFound) {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, managers.ErrVersionMismatch) {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusPreconditionFailed), http.StatusPreconditionFailed)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	setETag(writer, item.Version)
+	respondJSON(writer, item)
+}
+
+// readMergePatch - reads the body of PATCH request, which must be JSON Merge Patch.
+func readMergePatch(writer http.ResponseWriter, request *http.Request) ([]byte, bool) {
+	contentType := strings.TrimSpace(strings.Split(request.Header.Get("Content-Type"), ";")[0])
+	if contentType != "application/merge-patch+json" && contentType != "application/json" {
+		http.Error(writer, http.StatusText(http.StatusUnsupportedMediaType), http.StatusUnsupportedMediaType)
+		return nil, false
+	}
+
+	changes, err := io.ReadAll(request.Body)
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return nil, false
+	}
+	return changes, true
+}
+
+// handleManagerRemoveProductByID - archives the product by ID (manager).
+func (s *Server) handleManagerRemoveProductByID(writer http.ResponseWriter, request *http.Request) {
+
+	idParam, ok := mux.Vars(request)["id"]
+	if !ok {
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	productID, err := strconv.ParseInt(idParam, 10, 64)
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	version, ok := ifMatch(writer, request)
+	if !ok {
+		return
+	}
+
+	item, err := s.managersSvc.RemoveProductByID(request.Context(), productID, version)
+	respondProduct(writer, item, err)
+}
+
+// handleManagerGetCategories - gets the categories of products.
+func (s *Server) handleManagerGetCategories(writer http.ResponseWriter, request *http.Request) {
+	items, err := s.managersSvc.Categories(request.Context())
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(writer, items)
+}
+
+// handleManagerCreateCategory - creates the category of products.
+func (s *Server) handleManagerCreateCategory(writer http.ResponseWriter, request *http.Request) {
+	var item *types.Category
+	if err := json.NewDecoder(request.Body).Decode(&item); err != nil || item == nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	item, err := s.managersSvc.CreateCategory(request.Context(), item)
+	var validationErr *types.ValidationError
+	if errors.As(err, &validationErr) {
+		log.Println(err)
+		respondValidationError(writer, validationErr)
+		return
+	}
+	if errors.Is(err, managers.ErrCategoryExists) {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusConflict), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(writer, item)
+}
+
+// handleManagerGetTaxClasses - gets the tax classes of products.
+func (s *Server) handleManagerGetTaxClasses(writer http.ResponseWriter, request *http.Request) {
+	items, err := s.managersSvc.TaxClasses(request.Context())
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(writer, items)
+}
+
+// handleManagerCreateTaxClass - creates the tax class of products.
+func (s *Server) handleManagerCreateTaxClass(writer http.ResponseWriter, request *http.Request) {
+	var item *types.TaxClass
+	if err := json.NewDecoder(request.Body).Decode(&item); err != nil || item == nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	item, err := s.managersSvc.CreateTaxClass(request.Context(), item)
+	respondTaxClass(writer, item, err)
+}
+
+// handleManagerChangeTaxClass - changes the name and the rate of tax class.
+func (s *Server) handleManagerChangeTaxClass(writer http.ResponseWriter, request *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(request)["id"], 10, 64)
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	var item *types.TaxClass
+	if err = json.NewDecoder(request.Body).Decode(&item); err != nil || item == nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	item.ID = id
+
+	item, err = s.managersSvc.ChangeTaxClass(request.Context(), item)
+	respondTaxClass(writer, item, err)
+}
+
+// respondTaxClass - response with saved tax class or with the error of saving.
+func respondTaxClass(writer http.ResponseWriter, item *types.TaxClass, err error) {
+	var validationErr *types.ValidationError
+	if errors.As(err, &validationErr) {
+		log.Println(err)
+		respondValidationError(writer, validationErr)
+		return
+	}
+	if errors.Is(err, managers.ErrTaxClassExists) {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusConflict), http.StatusConflict)
+		return
+	}
+	if errors.Is(err, managers.ErrNotFound) {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(writer, item)
+}
+
+// handleManagerGetExchangeRates - gets the exchange rates of the currencies.
+func (s *Server) handleManagerGetExchangeRates(writer http.ResponseWriter, request *http.Request) {
+	items, err := s.managersSvc.ExchangeRates(request.Context())
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(writer, items)
+}
+
+// handleManagerSetExchangeRate - sets the exchange rate of the currency.
+func (s *Server) handleManagerSetExchangeRate(writer http.ResponseWriter, request *http.Request) {
+	var item *types.ExchangeRate
+	if err := json.NewDecoder(request.Body).Decode(&item); err != nil || item == nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	item.Currency = mux.Vars(request)["currency"]
+
+	item, err := s.managersSvc.SetExchangeRate(request.Context(), item)
+	var validationErr *types.ValidationError
+	if errors.As(err, &validationErr) {
+		log.Println(err)
+		respondValidationError(writer, validationErr)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(writer, item)
+}
+
+// handleManagerGetPromotions - gets all promotions.
+func (s *Server) handleManagerGetPromotions(writer http.ResponseWriter, request *http.Request) {
+	items, err := s.managersSvc.Promotions(request.Context())
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(writer, items)
+}
+
+// handleManagerCreatePromotion - creates the promotion.
+func (s *Server) handleManagerCreatePromotion(writer http.ResponseWriter, request *http.Request) {
+	var item *types.Promotion
+	if err := json.NewDecoder(request.Body).Decode(&item); err != nil || item == nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	item, err := s.managersSvc.CreatePromotion(request.Context(), item)
+	respondPromotion(writer, item, err)
+}
+
+// handleManagerChangePromotion - changes the promotion.
+func (s *Server) handleManagerChangePromotion(writer http.ResponseWriter, request *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(request)["id"], 10, 64)
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	var item *types.Promotion
+	if err = json.NewDecoder(request.Body).Decode(&item); err != nil || item == nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	item.ID = id
+
+	item, err = s.managersSvc.ChangePromotion(request.Context(), item)
+	respondPromotion(writer, item, err)
+}
+
+// handleManagerRemovePromotion - deactivates the promotion.
+func (s *Server) handleManagerRemovePromotion(writer http.ResponseWriter, request *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(request)["id"], 10, 64)
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	err = s.managersSvc.RemovePromotion(request.Context(), id)
+	if errors.Is(err, managers.ErrNotFound) {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(writer, map[string]interface{}{"id": id, "status": "ok"})
+}
+
+// respondPromotion - response with saved promotion or with the error of saving.
+func respondPromotion(writer http.ResponseWriter, item *types.Promotion, err error) {
+	var validationErr *types.ValidationError
+	if errors.As(err, &validationErr) {
+		log.Println(err)
+		respondValidationError(writer, validationErr)
+		return
+	}
+	if errors.Is(err, managers.ErrPromoCodeUsed) {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusConflict), http.StatusConflict)
+		return
+	}
+	if errors.Is(err, managers.ErrNotFound) {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(writer, item)
+}
+
+// handleManagerGetCustomers - gets information about customers, archived too with ?include=archived,
+// only active or inactive ones with ?active=true|false.
+func (s *Server) handleManagerGetCustomers(writer http.ResponseWriter, request *http.Request) {
+	active, err := activeFilter(request.URL.Query())
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	item, err := s.managersSvc.GetCustomer(request.Context(), includeArchived(request.URL.Query()), active)
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(writer, item)
+}
+
+// handleManagerGetCustomerByID - gets the customer by id, archived too.
+func (s *Server) handleManagerGetCustomerByID(writer http.ResponseWriter, request *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(request)["id"], 10, 64)
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	item, err := s.managersSvc.CustomerByID(request.Context(), id)
+	respondCustomer(writer, item, err)
+}
+
+// handleManagerCreateCustomer - creates the customer, who sets the password by password reset.
+func (s *Server) handleManagerCreateCustomer(writer http.ResponseWriter, request *http.Request) {
+	item := &types.Customers{Active: true}
+	if err := json.NewDecoder(request.Body).Decode(item); err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	item, err := s.managersSvc.CreateCustomer(request.Context(), item)
+	respondCustomer(writer, item, err)
+}
+
+// handleManagerReplaceCustomer - replaces the name, phone and activity of customer.
+func (s *Server) handleManagerReplaceCustomer(writer http.ResponseWriter, request *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(request)["id"], 10, 64)
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	version, ok := ifMatch(writer, request)
+	if !ok {
+		return
+	}
+
+	item := &types.Customers{Active: true}
+	if err = json.NewDecoder(request.Body).Decode(item); err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	item.ID = id
+
+	item, err = s.managersSvc.ReplaceCustomer(request.Context(), item, version)
+	respondCustomer(writer, item, err)
+}
+
+// handleManagerPatchCustomer - changes the fields of customer given in JSON Merge Patch.
+func (s *Server) handleManagerPatchCustomer(writer http.ResponseWriter, request *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(request)["id"], 10, 64)
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	version, ok := ifMatch(writer, request)
+	if !ok {
+		return
+	}
+
+	changes, ok := readMergePatch(writer, request)
+	if !ok {
+		return
+	}
+
+	item, err := s.managersSvc.PatchCustomer(request.Context(), id, version, changes)
+	respondCustomer(writer, item, err)
+}
+
+// respondCustomer - response with the customer or with the error of saving.
+func respondCustomer(writer http.ResponseWriter, item *types.Customers, err error) {
+	var validationErr *types.ValidationError
+	if errors.As(err, &validationErr) {
+		log.Println(err)
+		respondValidationError(writer, validationErr)
+		return
+	}
+	if errors.Is(err, managers.ErrInvalidPatch) {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, managers.ErrPhoneUsed) {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusConflict), http.StatusConflict)
+		return
+	}
+	if errors.Is(err, managers.ErrNotFound) {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, managers.ErrVersionMismatch) {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusPreconditionFailed), http.StatusPreconditionFailed)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	setETag(writer, item.Version)
+	respondJSON(writer, item)
+}
+
+// handleManagerRemoveCustomerByID - archives the customer by id (manager).
+func (s *Server) handleManagerRemoveCustomerByID(writer http.ResponseWriter, request *http.Request) {
+
+	idParam, ok := mux.Vars(request)["id"]
+	if !ok {
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	customerID, err := strconv.ParseInt(idParam, 10, 64)
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	version, ok := ifMatch(writer, request)
+	if !ok {
+		return
+	}
+
+	item, err := s.managersSvc.RemoveCustomerByID(request.Context(), customerID, version)
+	respondCustomer(writer, item, err)
+}
+
+// handleManagerRestoreProduct - returns the archived product to the catalog.
+func (s *Server) handleManagerRestoreProduct(writer http.ResponseWriter, request *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(request)["id"], 10, 64)
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	version, ok := ifMatch(writer, request)
+	if !ok {
+		return
+	}
+
+	item, err := s.managersSvc.RestoreProduct(request.Context(), id, version)
+	respondProduct(writer, item, err)
+}
+
+// handleManagerPurgeProduct - deletes the archived product which has no references.
+func (s *Server) handleManagerPurgeProduct(writer http.ResponseWriter, request *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(request)["id"], 10, 64)
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	version, ok := ifMatch(writer, request)
+	if !ok {
+		return
+	}
+
+	err = s.managersSvc.PurgeProduct(request.Context(), id, version)
+	respondPurge(writer, err)
+}
+
+// handleManagerRestoreCustomer - returns the archived customer.
+func (s *Server) handleManagerRestoreCustomer(writer http.ResponseWriter, request *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(request)["id"], 10, 64)
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	version, ok := ifMatch(writer, request)
+	if !ok {
+		return
+	}
+
+	item, err := s.managersSvc.RestoreCustomer(request.Context(), id, version)
+	respondCustomer(writer, item, err)
+}
+
+// handleManagerPurgeCustomer - deletes the archived customer which has no purchases.
+func (s *Server) handleManagerPurgeCustomer(writer http.ResponseWriter, request *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(request)["id"], 10, 64)
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	version, ok := ifMatch(writer, request)
+	if !ok {
+		return
+	}
+
+	err = s.managersSvc.PurgeCustomer(request.Context(), id, version)
+	respondPurge(writer, err)
+}
+
+// respondPurge - response on purge: 404 if the record isn't archived, 409 if it has references,
+// 412 if it has other version.
+func respondPurge(writer http.ResponseWriter, err error) {
+	if errors.Is(err, managers.ErrNotFound) {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, managers.ErrVersionMismatch) {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusPreconditionFailed), http.StatusPreconditionFailed)
+		return
+	}
+	if errors.Is(err, managers.ErrReferenced) {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusConflict), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// setETag - sets ETag header by the version of product or customer.
+func setETag(writer http.ResponseWriter, version int64) {
+	writer.Header().Set("ETag", strconv.Quote(strconv.FormatInt(version, 10)))
+}
+
+// ifMatch - reads the version expected by If-Match header, which is required on changes of products
+// and customers: 428 is answered without it, 412 if it isn't * or ETag given by the server.
+func ifMatch(writer http.ResponseWriter, request *http.Request) (int64, bool) {
+	value := strings.TrimSpace(request.Header.Get("If-Match"))
+	if value == "" {
+		http.Error(writer, http.StatusText(http.StatusPreconditionRequired), http.StatusPreconditionRequired)
+		return 0, false
+	}
+	if value == "*" {
+		return managers.AnyVersion, true
+	}
+
+	version, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(value, `"`), `"`), 10, 64)
+	if err != nil || version <= 0 || value != strconv.Quote(strconv.FormatInt(version, 10)) {
+		http.Error(writer, http.StatusText(http.StatusPreconditionFailed), http.StatusPreconditionFailed)
+		return 0, false
+	}
+	return version, true
+}
+
+// activeFilter - reads ?active=true|false, nil if it isn't given.
+func activeFilter(query url.Values) (*bool, error) {
+	value := query.Get("active")
+	if value == "" {
+		return nil, nil
+	}
+
+	active, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, err
+	}
+	return &active, nil
+}
+
+// includeArchived - checks that archived records are asked by ?include=archived.
+func includeArchived(query url.Values) bool {
+	for _, item := range strings.Split(query.Get("include"), ",") {
+		if strings.TrimSpace(item) == "archived" {
+			return true
+		}
+	}
+	return false
 }
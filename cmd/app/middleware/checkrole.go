@@ -5,14 +5,20 @@ import (
 	"net/http"
 )
 
-type HasAndRoleFunc func(ctx context.Context, roles ...string) bool
+type HasAnyRoleFunc func(ctx context.Context, id int64, roles ...string) bool
 
 // CheckRole - role verifying.
-func CheckRole(hasAnyRoleFunc HasAndRoleFunc, roles ...string) func(http.Handler) http.Handler {
+func CheckRole(hasAnyRoleFunc HasAnyRoleFunc, roles ...string) func(http.Handler) http.Handler {
 	return func(handler http.Handler) http.Handler {
 		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
-			if !hasAnyRoleFunc(request.Context(), roles...) {
-				http.Error(writer, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			id, err := Authentication(request.Context())
+			if err != nil {
+				RespondUnauthorized(writer, ReasonTokenMissing)
+				return
+			}
+
+			if !hasAnyRole(request.Context(), hasAnyRoleFunc, id, roles) {
+				RespondForbidden(writer)
 				return
 			}
 
@@ -20,3 +26,20 @@ func CheckRole(hasAnyRoleFunc HasAndRoleFunc, roles ...string) func(http.Handler
 		})
 	}
 }
+
+// hasAnyRole - checks roles carried by signed token, or asks hasAnyRoleFunc otherwise.
+func hasAnyRole(ctx context.Context, hasAnyRoleFunc HasAnyRoleFunc, id int64, roles []string) bool {
+	granted, ok := authenticationRoles(ctx)
+	if !ok {
+		return hasAnyRoleFunc(ctx, id, roles...)
+	}
+
+	for _, role := range roles {
+		for _, item := range granted {
+			if item == role {
+				return true
+			}
+		}
+	}
+	return false
+}
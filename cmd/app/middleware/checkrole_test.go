@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckRole(t *testing.T) {
+	hasAnyRoleFunc := func(ctx context.Context, id int64, roles ...string) bool {
+		for _, role := range roles {
+			if id == 1 && role == ADMIN {
+				return true
+			}
+		}
+		return false
+	}
+
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		status int
+	}{
+		{"not authenticated", context.Background(), http.StatusUnauthorized},
+		{"granted role", authenticatedAs(1, nil), http.StatusOK},
+		{"missing role", authenticatedAs(2, nil), http.StatusForbidden},
+		{"granted role of signed token", authenticatedAs(2, []string{MANAGER, ADMIN}), http.StatusOK},
+		{"missing role of signed token", authenticatedAs(1, []string{MANAGER}), http.StatusForbidden},
+		{"signed token without roles", authenticatedAs(1, []string{}), http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(test.ctx)
+			recorder := httptest.NewRecorder()
+			CheckRole(hasAnyRoleFunc, ADMIN)(authenticated).ServeHTTP(recorder, request)
+
+			checkResponse(t, recorder, test.status, "", "")
+			if test.status == http.StatusForbidden {
+				want := `Bearer realm="api", error="insufficient_scope"`
+				if header := recorder.Header().Get("WWW-Authenticate"); header != want {
+					t.Errorf("WWW-Authenticate = %q, want %q", header, want)
+				}
+			}
+		})
+	}
+}
+
+// authenticatedAs - context of the user authenticated by Authenticate, or by AuthenticateSigned
+// if roles are given.
+func authenticatedAs(id int64, roles []string) context.Context {
+	ctx := context.WithValue(context.Background(), authenticationContextKey, id)
+	ctx = context.WithValue(ctx, tokenContextKey, "token")
+	if roles != nil {
+		ctx = context.WithValue(ctx, rolesContextKey, roles)
+	}
+	return ctx
+}
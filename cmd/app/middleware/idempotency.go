@@ -0,0 +1,133 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/SardorMS/CRUD/pkg/idempotency"
+)
+
+// Header of the key by which repeated requests are recognized.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// maxIdempotencyKeyLength - the longest accepted key.
+const maxIdempotencyKeyLength = 255
+
+// IdempotencyStore - storage of idempotency keys with the responses.
+type IdempotencyStore interface {
+	Begin(ctx context.Context, scope string, key string, hash string) (*idempotency.Response, error)
+	Finish(ctx context.Context, scope string, key string, response *idempotency.Response) error
+	Release(ctx context.Context, scope string, key string) error
+}
+
+// Idempotency - makes the requests with Idempotency-Key header safe to retry, must be used
+// after Authenticate. The first response is stored with the key and the hash of body,
+// repeated request gets the stored response, request with other body under the same key
+// is answered with 422. Keys are separate for each user and route, requests without
+// the header are processed as usual.
+func Idempotency(store IdempotencyStore) func(http.Handler) http.Handler {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			key := request.Header.Get(IdempotencyKeyHeader)
+			if key == "" {
+				handler.ServeHTTP(writer, request)
+				return
+			}
+			if len(key) > maxIdempotencyKeyLength {
+				respondError(writer, http.StatusBadRequest, "idempotency_key_invalid")
+				return
+			}
+
+			id, err := Authentication(request.Context())
+			if err != nil {
+				RespondUnauthorized(writer, ReasonTokenMissing)
+				return
+			}
+
+			body, err := io.ReadAll(request.Body)
+			if err != nil {
+				log.Println(err)
+				http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			request.Body = io.NopCloser(bytes.NewReader(body))
+
+			sum := sha256.Sum256(body)
+			hash := hex.EncodeToString(sum[:])
+			scope := fmt.Sprintf("%s %s %d", request.Method, request.URL.Path, id)
+
+			stored, err := store.Begin(request.Context(), scope, key, hash)
+			switch {
+			case errors.Is(err, idempotency.ErrMismatch):
+				respondError(writer, http.StatusUnprocessableEntity, "idempotency_key_reused")
+				return
+			case errors.Is(err, idempotency.ErrInProgress):
+				respondError(writer, http.StatusConflict, "idempotency_key_in_progress")
+				return
+			case err != nil:
+				log.Println(err)
+				http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+
+			if stored != nil {
+				if stored.ContentType != "" {
+					writer.Header().Set("Content-Type", stored.ContentType)
+				}
+				writer.Header().Set("Idempotent-Replayed", "true")
+				writer.WriteHeader(stored.Status)
+				if _, err = writer.Write(stored.Body); err != nil {
+					log.Println(err)
+				}
+				return
+			}
+
+			recorder := &responseRecorder{ResponseWriter: writer, status: http.StatusOK}
+			handler.ServeHTTP(recorder, request)
+
+			// the response is already sent, so the key is saved even if the client has gone.
+			ctx := context.Background()
+			if recorder.status >= http.StatusInternalServerError {
+				err = store.Release(ctx, scope, key)
+			} else {
+				err = store.Finish(ctx, scope, key, &idempotency.Response{
+					Status:      recorder.status,
+					ContentType: writer.Header().Get("Content-Type"),
+					Body:        recorder.body.Bytes(),
+				})
+			}
+			if err != nil {
+				log.Println(err)
+			}
+		})
+	}
+}
+
+// responseRecorder - writes the response and keeps its status and body.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	r.wroteHeader = true
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
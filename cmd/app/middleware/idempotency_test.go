@@ -0,0 +1,271 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/SardorMS/CRUD/pkg/idempotency"
+)
+
+// memoryStore - idempotency keys kept in memory the same way as by idempotency.Service.
+type memoryStore struct {
+	mu   sync.Mutex
+	keys map[string]*storedKey
+}
+
+type storedKey struct {
+	hash     string
+	response *idempotency.Response
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{keys: make(map[string]*storedKey)}
+}
+
+func (s *memoryStore) Begin(ctx context.Context, scope string, key string, hash string) (*idempotency.Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, ok := s.keys[scope+" "+key]
+	if !ok {
+		s.keys[scope+" "+key] = &storedKey{hash: hash}
+		return nil, nil
+	}
+	if item.hash != hash {
+		return nil, idempotency.ErrMismatch
+	}
+	if item.response == nil {
+		return nil, idempotency.ErrInProgress
+	}
+	return item.response, nil
+}
+
+func (s *memoryStore) Finish(ctx context.Context, scope string, key string, response *idempotency.Response) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keys[scope+" "+key].response = response
+	return nil
+}
+
+func (s *memoryStore) Release(ctx context.Context, scope string, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if item, ok := s.keys[scope+" "+key]; ok && item.response == nil {
+		delete(s.keys, scope+" "+key)
+	}
+	return nil
+}
+
+// creating - handler which creates the sale with the next number, or fails while failing is set.
+type creating struct {
+	mu      sync.Mutex
+	calls   int
+	failing bool
+}
+
+func (c *creating) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	c.mu.Lock()
+	c.calls++
+	calls, failing := c.calls, c.failing
+	c.mu.Unlock()
+
+	if failing {
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	body, _ := io.ReadAll(request.Body)
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusCreated)
+	fmt.Fprintf(writer, `{"id":%d,"body":%s}`, calls, body)
+}
+
+// post - sends the request with the key and body as the authenticated user.
+func post(handler http.Handler, id int64, key string, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodPost, "/api/sales", strings.NewReader(body))
+	request = request.WithContext(authenticatedAs(id, nil))
+	if key != "" {
+		request.Header.Set(IdempotencyKeyHeader, key)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestIdempotency(t *testing.T) {
+	type step struct {
+		id       int64
+		key      string
+		body     string
+		status   int
+		response string
+		replayed bool
+	}
+
+	tests := []struct {
+		name  string
+		steps []step
+		calls int
+	}{
+		{
+			name: "replay with the same key",
+			steps: []step{
+				{1, "a", `{"qty":1}`, http.StatusCreated, `{"id":1,"body":{"qty":1}}`, false},
+				{1, "a", `{"qty":1}`, http.StatusCreated, `{"id":1,"body":{"qty":1}}`, true},
+				{1, "a", `{"qty":1}`, http.StatusCreated, `{"id":1,"body":{"qty":1}}`, true},
+			},
+			calls: 1,
+		},
+		{
+			name: "other body with the same key",
+			steps: []step{
+				{1, "a", `{"qty":1}`, http.StatusCreated, `{"id":1,"body":{"qty":1}}`, false},
+				{1, "a", `{"qty":2}`, http.StatusUnprocessableEntity, `{"error":"idempotency_key_reused"}`, false},
+			},
+			calls: 1,
+		},
+		{
+			name: "other keys",
+			steps: []step{
+				{1, "a", `{"qty":1}`, http.StatusCreated, `{"id":1,"body":{"qty":1}}`, false},
+				{1, "b", `{"qty":1}`, http.StatusCreated, `{"id":2,"body":{"qty":1}}`, false},
+			},
+			calls: 2,
+		},
+		{
+			name: "same key of other users",
+			steps: []step{
+				{1, "a", `{"qty":1}`, http.StatusCreated, `{"id":1,"body":{"qty":1}}`, false},
+				{2, "a", `{"qty":1}`, http.StatusCreated, `{"id":2,"body":{"qty":1}}`, false},
+			},
+			calls: 2,
+		},
+		{
+			name: "without key",
+			steps: []step{
+				{1, "", `{"qty":1}`, http.StatusCreated, `{"id":1,"body":{"qty":1}}`, false},
+				{1, "", `{"qty":1}`, http.StatusCreated, `{"id":2,"body":{"qty":1}}`, false},
+			},
+			calls: 2,
+		},
+		{
+			name: "too long key",
+			steps: []step{
+				{1, strings.Repeat("k", maxIdempotencyKeyLength+1), `{"qty":1}`, http.StatusBadRequest, `{"error":"idempotency_key_invalid"}`, false},
+			},
+			calls: 0,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			next := &creating{}
+			handler := Idempotency(newMemoryStore())(next)
+
+			for i, step := range test.steps {
+				recorder := post(handler, step.id, step.key, step.body)
+				if recorder.Code != step.status {
+					t.Fatalf("step %d: status = %d, want %d", i, recorder.Code, step.status)
+				}
+				if body := strings.TrimSpace(recorder.Body.String()); body != step.response {
+					t.Errorf("step %d: body = %s, want %s", i, body, step.response)
+				}
+				if replayed := recorder.Header().Get("Idempotent-Replayed") == "true"; replayed != step.replayed {
+					t.Errorf("step %d: replayed = %v, want %v", i, replayed, step.replayed)
+				}
+				if step.replayed && recorder.Header().Get("Content-Type") != "application/json" {
+					t.Errorf("step %d: Content-Type = %q, want application/json", i, recorder.Header().Get("Content-Type"))
+				}
+			}
+			if next.calls != test.calls {
+				t.Errorf("handler called %d times, want %d", next.calls, test.calls)
+			}
+		})
+	}
+}
+
+func TestIdempotencyRetryAfterFailure(t *testing.T) {
+	next := &creating{failing: true}
+	handler := Idempotency(newMemoryStore())(next)
+
+	if recorder := post(handler, 1, "a", `{"qty":1}`); recorder.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want %d", recorder.Code, http.StatusInternalServerError)
+	}
+
+	next.failing = false
+	recorder := post(handler, 1, "a", `{"qty":1}`)
+	if recorder.Code != http.StatusCreated || recorder.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("retry status = %d, replayed = %q, want processed again", recorder.Code, recorder.Header().Get("Idempotent-Replayed"))
+	}
+	if next.calls != 2 {
+		t.Errorf("handler called %d times, want 2", next.calls)
+	}
+}
+
+func TestIdempotencyInProgress(t *testing.T) {
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	next := http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		close(started)
+		<-finish
+		writer.WriteHeader(http.StatusCreated)
+	})
+	handler := Idempotency(newMemoryStore())(next)
+
+	first := make(chan *httptest.ResponseRecorder)
+	go func() {
+		first <- post(handler, 1, "a", `{"qty":1}`)
+	}()
+	<-started
+
+	// the same request and the request with other body while the first one isn't finished.
+	const concurrent = 5
+	var wg sync.WaitGroup
+	codes := make(chan int, concurrent*2)
+	for i := 0; i < concurrent; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			codes <- post(handler, 1, "a", `{"qty":1}`).Code
+		}()
+		go func() {
+			defer wg.Done()
+			codes <- post(handler, 1, "a", `{"qty":2}`).Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	conflicts, mismatches := 0, 0
+	for code := range codes {
+		switch code {
+		case http.StatusConflict:
+			conflicts++
+		case http.StatusUnprocessableEntity:
+			mismatches++
+		default:
+			t.Errorf("concurrent status = %d, want %d or %d", code, http.StatusConflict, http.StatusUnprocessableEntity)
+		}
+	}
+	if conflicts != concurrent || mismatches != concurrent {
+		t.Errorf("conflicts = %d, mismatches = %d, want %d of each", conflicts, mismatches, concurrent)
+	}
+
+	close(finish)
+	if recorder := <-first; recorder.Code != http.StatusCreated {
+		t.Fatalf("first status = %d, want %d", recorder.Code, http.StatusCreated)
+	}
+
+	recorder := post(handler, 1, "a", `{"qty":1}`)
+	if recorder.Code != http.StatusCreated || recorder.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("after finish status = %d, replayed = %q, want replayed %d", recorder.Code, recorder.Header().Get("Idempotent-Replayed"), http.StatusCreated)
+	}
+}
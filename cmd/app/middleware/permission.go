@@ -0,0 +1,103 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+)
+
+var ErrNoPermissions = errors.New("no permissions")
+
+// A key by which the permissions of authenticated user will be added.
+var permissionsContextKey = &contextKey{"permissions context"}
+
+type PermissionsFunc func(ctx context.Context, id int64) ([]string, error)
+
+// permissionSet - permissions of authenticated user, loaded at most once per request.
+type permissionSet struct {
+	once  sync.Once
+	load  func() ([]string, error)
+	items map[string]bool
+	err   error
+}
+
+// has - loads the permissions on first use and checks the permission.
+func (p *permissionSet) has(permission string) (bool, error) {
+	p.once.Do(func() {
+		items, err := p.load()
+		if err != nil {
+			p.err = err
+			return
+		}
+
+		p.items = make(map[string]bool, len(items))
+		for _, item := range items {
+			p.items[item] = true
+		}
+	})
+
+	if p.err != nil {
+		return false, p.err
+	}
+	return p.items[permission], nil
+}
+
+// Permissions - makes the permissions of authenticated user available to handlers,
+// must be used after Authenticate.
+func Permissions(permissionsFunc PermissionsFunc) func(http.Handler) http.Handler {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			id, err := Authentication(request.Context())
+			if err != nil {
+				handler.ServeHTTP(writer, request)
+				return
+			}
+
+			ctx := request.Context()
+			set := &permissionSet{load: func() ([]string, error) {
+				return permissionsFunc(ctx, id)
+			}}
+
+			request = request.WithContext(context.WithValue(ctx, permissionsContextKey, set))
+			handler.ServeHTTP(writer, request)
+		})
+	}
+}
+
+// HasPermission - helper function, checks that authenticated user has the permission.
+func HasPermission(ctx context.Context, permission string) (bool, error) {
+	if set, ok := ctx.Value(permissionsContextKey).(*permissionSet); ok {
+		return set.has(permission)
+	}
+	return false, ErrNoPermissions
+}
+
+// RequirePermission - permissions verifying, user must have all of them.
+func RequirePermission(permissions ...string) func(http.Handler) http.Handler {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			if _, err := Authentication(request.Context()); err != nil {
+				RespondUnauthorized(writer, ReasonTokenMissing)
+				return
+			}
+
+			for _, permission := range permissions {
+				ok, err := HasPermission(request.Context(), permission)
+				if err != nil {
+					log.Println(err)
+					http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+					return
+				}
+
+				if !ok {
+					RespondForbidden(writer)
+					return
+				}
+			}
+
+			handler.ServeHTTP(writer, request)
+		})
+	}
+}
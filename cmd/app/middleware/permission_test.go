@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequirePermission(t *testing.T) {
+	tests := []struct {
+		name        string
+		permissions []string
+		err         error
+		required    []string
+		status      int
+	}{
+		{"granted", []string{"products:read", "products:write"}, nil, []string{"products:write"}, http.StatusOK},
+		{"all granted", []string{"products:read", "products:write"}, nil, []string{"products:read", "products:write"}, http.StatusOK},
+		{"missing permission", []string{"products:read"}, nil, []string{"products:write"}, http.StatusForbidden},
+		{"one of required missing", []string{"products:read"}, nil, []string{"products:read", "products:write"}, http.StatusForbidden},
+		{"no permissions", nil, nil, []string{"products:read"}, http.StatusForbidden},
+		{"failed to load", nil, errors.New("connection refused"), []string{"products:read"}, http.StatusInternalServerError},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			loads := 0
+			permissionsFunc := func(ctx context.Context, id int64) ([]string, error) {
+				loads++
+				return test.permissions, test.err
+			}
+
+			request := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(authenticatedAs(1, nil))
+			recorder := httptest.NewRecorder()
+			handler := Permissions(permissionsFunc)(RequirePermission(test.required...)(authenticated))
+			handler.ServeHTTP(recorder, request)
+
+			checkResponse(t, recorder, test.status, "forbidden", "")
+			if loads != 1 {
+				t.Errorf("permissions loaded %d times, want 1", loads)
+			}
+		})
+	}
+}
+
+func TestRequirePermissionNotAuthenticated(t *testing.T) {
+	permissionsFunc := func(ctx context.Context, id int64) ([]string, error) {
+		t.Error("permissions loaded without authenticated user")
+		return nil, nil
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	recorder := httptest.NewRecorder()
+	Permissions(permissionsFunc)(RequirePermission("products:read")(authenticated)).ServeHTTP(recorder, request)
+
+	checkResponse(t, recorder, http.StatusUnauthorized, ReasonTokenMissing, `Bearer realm="api"`)
+}
@@ -2,9 +2,16 @@ package middleware
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"strings"
+
+	"github.com/SardorMS/CRUD/pkg/customers"
+	"github.com/SardorMS/CRUD/pkg/managers"
+	"github.com/SardorMS/CRUD/pkg/tokens"
 )
 
 const (
@@ -12,11 +19,29 @@ const (
 	ADMIN   = "ADMIN"
 )
 
+// Reasons of failed authentication, returned to the client.
+const (
+	ReasonTokenMissing  = "token_missing"
+	ReasonTokenNotFound = "token_not_found"
+	ReasonTokenExpired  = "token_expired"
+	ReasonTokenInvalid  = "token_invalid"
+	ReasonTokenRevoked  = "token_revoked"
+)
+
 var ErrNoAuthentication = errors.New("no authentication")
 
+// Realm of the WWW-Authenticate challenge.
+const realm = "api"
+
 // A variable that will be the key by which the value will be added.
 var authenticationContextKey = &contextKey{"authentication context"}
 
+// A key by which the token of authenticated user will be added.
+var tokenContextKey = &contextKey{"token context"}
+
+// A key by which the roles carried by signed token will be added.
+var rolesContextKey = &contextKey{"roles context"}
+
 // Non-exportable type.
 type contextKey struct {
 	name string
@@ -28,21 +53,34 @@ func (c *contextKey) String() string {
 
 type IDFunc func(ctx context.Context, token string) (int64, error)
 
-// Authenticate - authentication procedure
+// Authenticate - authentication procedure, must be used on protected routes only:
+// request without valid bearer token is answered with 401.
 func Authenticate(idFunc IDFunc) func(http.Handler) http.Handler {
 	return func(handler http.Handler) http.Handler {
 		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
-			token := request.Header.Get("Authorization")
+			token, reason := bearerToken(request)
+			if reason != "" {
+				RespondUnauthorized(writer, reason)
+				return
+			}
 
 			id, err := idFunc(request.Context(), token)
 			if err != nil {
 				log.Println(err, "Not Authorized")
-				http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				switch {
+				case errors.Is(err, customers.ErrTokenExpired), errors.Is(err, managers.ErrTokenExpired):
+					RespondUnauthorized(writer, ReasonTokenExpired)
+				case errors.Is(err, customers.ErrTokenNotFound), errors.Is(err, managers.ErrTokenNotFound):
+					RespondUnauthorized(writer, ReasonTokenNotFound)
+				default:
+					http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				}
 				return
 			}
 
 			// give a value by a key.
 			ctx := context.WithValue(request.Context(), authenticationContextKey, id)
+			ctx = context.WithValue(ctx, tokenContextKey, token)
 			request = request.WithContext(ctx)
 
 			handler.ServeHTTP(writer, request)
@@ -50,6 +88,65 @@ func Authenticate(idFunc IDFunc) func(http.Handler) http.Handler {
 	}
 }
 
+type VerifyFunc func(token string) (*tokens.Claims, error)
+
+// AuthenticateSigned - authentication procedure by signed tokens of the kind,
+// the token is verified without database.
+func AuthenticateSigned(verifyFunc VerifyFunc, kind string) func(http.Handler) http.Handler {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			token, reason := bearerToken(request)
+			if reason != "" {
+				RespondUnauthorized(writer, reason)
+				return
+			}
+
+			claims, err := verifyFunc(token)
+			if err != nil {
+				log.Println(err, "Not Authorized")
+				switch {
+				case errors.Is(err, tokens.ErrTokenExpired):
+					RespondUnauthorized(writer, ReasonTokenExpired)
+				case errors.Is(err, tokens.ErrTokenRevoked):
+					RespondUnauthorized(writer, ReasonTokenRevoked)
+				default:
+					RespondUnauthorized(writer, ReasonTokenInvalid)
+				}
+				return
+			}
+
+			if claims.Kind != kind {
+				RespondUnauthorized(writer, ReasonTokenInvalid)
+				return
+			}
+
+			ctx := context.WithValue(request.Context(), authenticationContextKey, claims.Subject)
+			ctx = context.WithValue(ctx, tokenContextKey, token)
+			if claims.Roles != nil {
+				ctx = context.WithValue(ctx, rolesContextKey, claims.Roles)
+			}
+			request = request.WithContext(ctx)
+
+			handler.ServeHTTP(writer, request)
+		})
+	}
+}
+
+// bearerToken - extracts the token from "Authorization: Bearer <token>" header,
+// returns the reason of failure if there is no such token.
+func bearerToken(request *http.Request) (string, string) {
+	header := request.Header.Get("Authorization")
+	if header == "" {
+		return "", ReasonTokenMissing
+	}
+
+	parts := strings.Fields(header)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+		return "", ReasonTokenInvalid
+	}
+	return parts[1], ""
+}
+
 // Athuntecation - helper function, to extract value from context.
 func Authentication(ctx context.Context) (int64, error) {
 	if value, ok := ctx.Value(authenticationContextKey).(int64); ok {
@@ -57,3 +154,44 @@ func Authentication(ctx context.Context) (int64, error) {
 	}
 	return 0, ErrNoAuthentication
 }
+
+// AuthenticationToken - helper function, to extract token of authenticated user from context.
+func AuthenticationToken(ctx context.Context) (string, error) {
+	if value, ok := ctx.Value(tokenContextKey).(string); ok {
+		return value, nil
+	}
+	return "", ErrNoAuthentication
+}
+
+// authenticationRoles - helper function, to extract roles carried by signed token from context.
+func authenticationRoles(ctx context.Context) ([]string, bool) {
+	roles, ok := ctx.Value(rolesContextKey).([]string)
+	return roles, ok
+}
+
+// RespondUnauthorized - answers 401 with the challenge and the reason of failed authentication.
+func RespondUnauthorized(writer http.ResponseWriter, reason string) {
+	challenge := fmt.Sprintf("Bearer realm=%q", realm)
+	if reason != ReasonTokenMissing {
+		challenge += fmt.Sprintf(", error=\"invalid_token\", error_description=%q", reason)
+	}
+
+	writer.Header().Set("WWW-Authenticate", challenge)
+	respondError(writer, http.StatusUnauthorized, reason)
+}
+
+// RespondForbidden - answers 403 when authenticated user has not enough rights.
+func RespondForbidden(writer http.ResponseWriter) {
+	writer.Header().Set("WWW-Authenticate", fmt.Sprintf("Bearer realm=%q, error=\"insufficient_scope\"", realm))
+	respondError(writer, http.StatusForbidden, "forbidden")
+}
+
+// respondError - answers the status with the reason in JSON.
+func respondError(writer http.ResponseWriter, status int, reason string) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	err := json.NewEncoder(writer).Encode(map[string]string{"error": reason})
+	if err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,158 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/SardorMS/CRUD/pkg/customers"
+	"github.com/SardorMS/CRUD/pkg/managers"
+	"github.com/SardorMS/CRUD/pkg/tokens"
+)
+
+// authenticated - handler which answers with the id of authenticated user.
+var authenticated = http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+	id, err := Authentication(request.Context())
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusTeapot)
+		return
+	}
+	fmt.Fprint(writer, id)
+})
+
+func TestAuthenticate(t *testing.T) {
+	idFunc := func(ctx context.Context, token string) (int64, error) {
+		switch token {
+		case "valid":
+			return 1, nil
+		case "expired":
+			return 0, managers.ErrTokenExpired
+		case "customer-expired":
+			return 0, customers.ErrTokenExpired
+		case "failed":
+			return 0, errors.New("connection refused")
+		}
+		return 0, managers.ErrTokenNotFound
+	}
+
+	tests := []struct {
+		name      string
+		header    string
+		status    int
+		reason    string
+		challenge string
+	}{
+		{"valid", "Bearer valid", http.StatusOK, "", ""},
+		{"lower case scheme", "bearer valid", http.StatusOK, "", ""},
+		{"missing header", "", http.StatusUnauthorized, ReasonTokenMissing, `Bearer realm="api"`},
+		{"basic scheme", "Basic dXNlcjpwYXNz", http.StatusUnauthorized, ReasonTokenInvalid,
+			`Bearer realm="api", error="invalid_token", error_description="token_invalid"`},
+		{"scheme without token", "Bearer", http.StatusUnauthorized, ReasonTokenInvalid,
+			`Bearer realm="api", error="invalid_token", error_description="token_invalid"`},
+		{"token with spaces", "Bearer valid extra", http.StatusUnauthorized, ReasonTokenInvalid,
+			`Bearer realm="api", error="invalid_token", error_description="token_invalid"`},
+		{"unknown token", "Bearer unknown", http.StatusUnauthorized, ReasonTokenNotFound,
+			`Bearer realm="api", error="invalid_token", error_description="token_not_found"`},
+		{"expired token", "Bearer expired", http.StatusUnauthorized, ReasonTokenExpired,
+			`Bearer realm="api", error="invalid_token", error_description="token_expired"`},
+		{"expired customer token", "Bearer customer-expired", http.StatusUnauthorized, ReasonTokenExpired,
+			`Bearer realm="api", error="invalid_token", error_description="token_expired"`},
+		{"failed lookup", "Bearer failed", http.StatusInternalServerError, "", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			if test.header != "" {
+				request.Header.Set("Authorization", test.header)
+			}
+			recorder := httptest.NewRecorder()
+			Authenticate(idFunc)(authenticated).ServeHTTP(recorder, request)
+
+			checkResponse(t, recorder, test.status, test.reason, test.challenge)
+			if test.status == http.StatusOK && recorder.Body.String() != "1" {
+				t.Errorf("authenticated id = %q, want 1", recorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestAuthenticateSigned(t *testing.T) {
+	verifyFunc := func(token string) (*tokens.Claims, error) {
+		switch token {
+		case "manager":
+			return &tokens.Claims{Subject: 2, Kind: tokens.KindManager, Roles: []string{ADMIN}}, nil
+		case "customer":
+			return &tokens.Claims{Subject: 3, Kind: tokens.KindCustomer}, nil
+		case "expired":
+			return nil, tokens.ErrTokenExpired
+		case "revoked":
+			return nil, tokens.ErrTokenRevoked
+		}
+		return nil, tokens.ErrInvalidToken
+	}
+
+	tests := []struct {
+		name   string
+		header string
+		status int
+		reason string
+	}{
+		{"valid", "Bearer manager", http.StatusOK, ""},
+		{"missing header", "", http.StatusUnauthorized, ReasonTokenMissing},
+		{"basic scheme", "Basic manager", http.StatusUnauthorized, ReasonTokenInvalid},
+		{"other kind", "Bearer customer", http.StatusUnauthorized, ReasonTokenInvalid},
+		{"expired token", "Bearer expired", http.StatusUnauthorized, ReasonTokenExpired},
+		{"revoked token", "Bearer revoked", http.StatusUnauthorized, ReasonTokenRevoked},
+		{"bad signature", "Bearer forged", http.StatusUnauthorized, ReasonTokenInvalid},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			if test.header != "" {
+				request.Header.Set("Authorization", test.header)
+			}
+			recorder := httptest.NewRecorder()
+			AuthenticateSigned(verifyFunc, tokens.KindManager)(authenticated).ServeHTTP(recorder, request)
+
+			checkResponse(t, recorder, test.status, test.reason, "")
+			if test.status == http.StatusOK && recorder.Body.String() != "2" {
+				t.Errorf("authenticated id = %q, want 2", recorder.Body.String())
+			}
+		})
+	}
+}
+
+// checkResponse - checks the status, and the reason with the challenge of failed authentication.
+func checkResponse(t *testing.T, recorder *httptest.ResponseRecorder, status int, reason string, challenge string) {
+	t.Helper()
+
+	if recorder.Code != status {
+		t.Fatalf("status = %d, want %d", recorder.Code, status)
+	}
+	if status != http.StatusUnauthorized && status != http.StatusForbidden {
+		return
+	}
+
+	header := recorder.Header().Get("WWW-Authenticate")
+	if !strings.HasPrefix(header, `Bearer realm="api"`) {
+		t.Errorf("WWW-Authenticate = %q, want Bearer challenge", header)
+	}
+	if challenge != "" && header != challenge {
+		t.Errorf("WWW-Authenticate = %q, want %q", header, challenge)
+	}
+
+	body := map[string]string{}
+	if err := json.NewDecoder(recorder.Body).Decode(&body); err != nil {
+		t.Fatalf("decode body: %v", err)
+	}
+	if reason != "" && body["error"] != reason {
+		t.Errorf("error = %q, want %q", body["error"], reason)
+	}
+}
@@ -1,19 +1,35 @@
 package app
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
+	"time"
 
 	"github.com/SardorMS/CRUD/cmd/app/middleware"
+	"github.com/SardorMS/CRUD/pkg/catalog"
 	"github.com/SardorMS/CRUD/pkg/customers"
+	"github.com/SardorMS/CRUD/pkg/idempotency"
+	"github.com/SardorMS/CRUD/pkg/logins"
 	"github.com/SardorMS/CRUD/pkg/managers"
+	"github.com/SardorMS/CRUD/pkg/receipts"
+	"github.com/SardorMS/CRUD/pkg/sales"
+	"github.com/SardorMS/CRUD/pkg/tokens"
+	"github.com/SardorMS/CRUD/pkg/types"
 	"github.com/gorilla/mux"
 )
 
 const (
 	GET    = "GET"
 	POST   = "POST"
+	PUT    = "PUT"
+	PATCH  = "PATCH"
 	DELETE = "DELETE"
 )
 
@@ -22,14 +38,21 @@ type Server struct {
 	mux          *mux.Router
 	customersSvc *customers.Service
 	managersSvc  *managers.Service
+	tokensSvc    *tokens.Service
+	receiptsSvc  *receipts.Service
+	idempotency  *idempotency.Service
 }
 
 // NewServer - constructor function to create a new server.
-func NewServer(mux *mux.Router, customersSvc *customers.Service, managersSvc *managers.Service) *Server {
+func NewServer(mux *mux.Router, customersSvc *customers.Service, managersSvc *managers.Service, tokensSvc *tokens.Service,
+	receiptsSvc *receipts.Service, idempotencySvc *idempotency.Service) *Server {
 	return &Server{
 		mux:          mux,
 		customersSvc: customersSvc,
 		managersSvc:  managersSvc,
+		tokensSvc:    tokensSvc,
+		receiptsSvc:  receiptsSvc,
+		idempotency:  idempotencySvc,
 	}
 }
 
@@ -44,35 +67,292 @@ func (s *Server) Init() {
 	// Use Logger middleware.
 	s.mux.Use(middleware.Logger)
 	
-	// Authenticate customers routes by token and create prefix /api/customers.
+	// Customers routes available without token, prefix /api/customers.
+	customersPublic := s.mux.PathPrefix("/api/customers").Subrouter()
+	customersPublic.HandleFunc("", s.handleCustomerRegistration).Methods(POST)
+	customersPublic.HandleFunc("/token", s.handleCustomerGetToken).Methods(POST)
+	customersPublic.HandleFunc("/token/refresh", s.handleCustomerRefreshToken).Methods(POST)
+	customersPublic.HandleFunc("/password/reset", s.handleCustomerRequestPasswordReset).Methods(POST)
+	customersPublic.HandleFunc("/password/reset/confirm", s.handleCustomerResetPassword).Methods(POST)
+	customersPublic.HandleFunc("/products", s.handleCustomerGetProducts).Methods(GET)
+	customersPublic.HandleFunc("/products/barcode/{barcode:[0-9]+}", s.handleCustomerGetProductByBarcode).Methods(GET)
+	customersPublic.HandleFunc("/categories", s.handleCustomerGetCategories).Methods(GET)
+
+	// Authenticate customers routes by token.
 	customerAuthenticateMd := middleware.Authenticate(s.customersSvc.IDByToken)
+	if s.tokensSvc.Enabled() {
+		customerAuthenticateMd = middleware.AuthenticateSigned(s.tokensSvc.Verify, tokens.KindCustomer)
+	}
 	customersSubrouter := s.mux.PathPrefix("/api/customers").Subrouter()
 	customersSubrouter.Use(customerAuthenticateMd)
 
+	// Requests which create sales can be retried with the same Idempotency-Key.
+	idempotent := middleware.Idempotency(s.idempotency)
+
 	// Customers routes - path handlers (sub routes).
-	customersSubrouter.HandleFunc("", s.handleCustomerRegistration).Methods(POST)
-	customersSubrouter.HandleFunc("/token", s.handleCustomerGetToken).Methods(POST)
-	customersSubrouter.HandleFunc("/products", s.handleCustomerGetProducts).Methods(GET)
+	customersSubrouter.HandleFunc("/token", s.handleCustomerRevokeToken).Methods(DELETE)
+	customersSubrouter.HandleFunc("/sessions", s.handleCustomerGetSessions).Methods(GET)
+	customersSubrouter.HandleFunc("/sessions", s.handleCustomerRevokeSessions).Methods(DELETE)
+	customersSubrouter.HandleFunc("/password", s.handleCustomerChangePassword).Methods(PUT)
 	customersSubrouter.HandleFunc("/purchases", s.handleCustomerGetPurchases).Methods(GET)
-	customersSubrouter.HandleFunc("/purchases", s.handleCustomerMakePurchase).Methods(POST)
+	customersSubrouter.HandleFunc("/purchases/{id:[0-9]+}/receipt", s.handleCustomerGetReceipt).Methods(GET)
+	customersSubrouter.HandleFunc("/cart", s.handleCustomerGetCart).Methods(GET)
+	customersSubrouter.HandleFunc("/cart", s.handleCustomerAddToCart).Methods(POST)
+	customersSubrouter.Handle("/cart/checkout", idempotent(http.HandlerFunc(s.handleCustomerCheckout))).Methods(POST)
+	customersSubrouter.HandleFunc("/cart/{productID:[0-9]+}", s.handleCustomerChangeCartItem).Methods(PUT)
+	customersSubrouter.HandleFunc("/cart/{productID:[0-9]+}", s.handleCustomerRemoveFromCart).Methods(DELETE)
 
-	// Authenticate customers routes by token and create prefix /api/customers.
+	// Managers routes available without token, prefix /api/managers.
+	managersPublic := s.mux.PathPrefix("/api/managers").Subrouter()
+	managersPublic.HandleFunc("/token", s.handleManagerGetToken).Methods(POST)
+	managersPublic.HandleFunc("/token/refresh", s.handleManagerRefreshToken).Methods(POST)
+	managersPublic.HandleFunc("/password/setup", s.handleManagerSetupPassword).Methods(POST)
+
+	// Authenticate managers routes by token.
 	managerAuthenticateMd := middleware.Authenticate(s.managersSvc.IDByToken)
+	if s.tokensSvc.Enabled() {
+		managerAuthenticateMd = middleware.AuthenticateSigned(s.tokensSvc.Verify, tokens.KindManager)
+	}
 	managersSubrouter := s.mux.PathPrefix("/api/managers").Subrouter()
 	managersSubrouter.Use(managerAuthenticateMd)
+	managersSubrouter.Use(middleware.Permissions(s.managersSvc.ManagerPermissions))
+
+	// Roles and permissions required by managers routes.
+	adminOnly := middleware.CheckRole(s.managersSvc.HasAnyRole, middleware.ADMIN)
+	can := func(permission string, handler http.HandlerFunc) http.Handler {
+		return middleware.RequirePermission(permission)(handler)
+	}
 
 	// Managers routes - path handlers (sub routes).
-	managersSubrouter.HandleFunc("", s.handleManagerRegistration).Methods(POST)
-	managersSubrouter.HandleFunc("/token", s.handleManagerGetToken).Methods(POST) 
-	managersSubrouter.HandleFunc("/sales", s.handleManagerGetSales).Methods(GET) 
-	managersSubrouter.HandleFunc("/sales", s.handleManagerMakeSale).Methods(POST) 
-	managersSubrouter.HandleFunc("/products", s.handleManagerGetProducts).Methods(GET)
-	managersSubrouter.HandleFunc("/products", s.handleManagerChangeProduct).Methods(POST) 
-	managersSubrouter.HandleFunc("/products/{id:[0-9]+}", s.handleManagerRemoveProductByID).Methods(DELETE)
-	managersSubrouter.HandleFunc("/customers", s.handleManagerGetCustomers).Methods(GET)
-	managersSubrouter.HandleFunc("/customers", s.handleManagerChangeCustomer).Methods(POST)
-	managersSubrouter.HandleFunc("/customers/{id:[0-9]+}", s.handleManagerRemoveCustomerByID).Methods(DELETE)
+	managersSubrouter.Handle("", can(managers.PermManagersWrite, s.handleManagerRegistration)).Methods(POST)
+	managersSubrouter.HandleFunc("/token", s.handleManagerRevokeToken).Methods(DELETE)
+	managersSubrouter.HandleFunc("/sessions", s.handleManagerGetSessions).Methods(GET)
+	managersSubrouter.HandleFunc("/sessions", s.handleManagerRevokeSessions).Methods(DELETE)
+	managersSubrouter.HandleFunc("/password", s.handleManagerChangePassword).Methods(PUT)
+	managersSubrouter.Handle("/{id:[0-9]+}/sessions", adminOnly(http.HandlerFunc(s.handleManagerRevokeManagerSessions))).Methods(DELETE)
+	managersSubrouter.Handle("/{id:[0-9]+}/roles", adminOnly(http.HandlerFunc(s.handleManagerSetRoles))).Methods(PUT)
+	managersSubrouter.Handle("/{id:[0-9]+}/lock", adminOnly(http.HandlerFunc(s.handleManagerUnlockManager))).Methods(DELETE)
+	managersSubrouter.Handle("/logins", adminOnly(http.HandlerFunc(s.handleManagerGetLogins))).Methods(GET)
+	managersSubrouter.Handle("/roles", adminOnly(http.HandlerFunc(s.handleManagerGetRoles))).Methods(GET)
+	managersSubrouter.Handle("/roles", adminOnly(http.HandlerFunc(s.handleManagerCreateRole))).Methods(POST)
+	managersSubrouter.Handle("/roles/{name}", adminOnly(http.HandlerFunc(s.handleManagerChangeRole))).Methods(PUT)
+	managersSubrouter.Handle("/roles/{name}", adminOnly(http.HandlerFunc(s.handleManagerRemoveRole))).Methods(DELETE)
+	managersSubrouter.Handle("/permissions", adminOnly(http.HandlerFunc(s.handleManagerGetPermissions))).Methods(GET)
+	managersSubrouter.Handle("/sales", can(managers.PermSalesRead, s.handleManagerGetSales)).Methods(GET)
+	managersSubrouter.Handle("/sales/total", can(managers.PermSalesRead, s.handleManagerGetSalesTotal)).Methods(GET)
+	managersSubrouter.Handle("/sales/{id:[0-9]+}", can(managers.PermSalesRead, s.handleManagerGetSaleByID)).Methods(GET)
+	managersSubrouter.Handle("/sales/{id:[0-9]+}/receipt", can(managers.PermSalesRead, s.handleManagerGetReceipt)).Methods(GET)
+	managersSubrouter.Handle("/sales", middleware.RequirePermission(managers.PermSalesWrite)(idempotent(http.HandlerFunc(s.handleManagerMakeSale)))).Methods(POST)
+	managersSubrouter.Handle("/sales/{id:[0-9]+}/returns", can(managers.PermSalesWrite, s.handleManagerMakeReturn)).Methods(POST)
+	managersSubrouter.Handle("/sales/{id:[0-9]+}/payments", can(managers.PermSalesWrite, s.handleManagerAddPayments)).Methods(POST)
+	managersSubrouter.Handle("/sales/{id:[0-9]+}/void", can(managers.PermSalesVoid, s.handleManagerVoidSale)).Methods(POST)
+	managersSubrouter.Handle("/products", can(managers.PermProductsRead, s.handleManagerGetProducts)).Methods(GET)
+	managersSubrouter.Handle("/products", can(managers.PermProductsWrite, s.handleManagerCreateProduct)).Methods(POST)
+	managersSubrouter.Handle("/products/barcode/{barcode:[0-9]+}", can(managers.PermProductsRead, s.handleManagerGetProductByBarcode)).Methods(GET)
+	managersSubrouter.Handle("/products/{id:[0-9]+}", can(managers.PermProductsRead, s.handleManagerGetProductByID)).Methods(GET)
+	managersSubrouter.Handle("/products/{id:[0-9]+}", can(managers.PermProductsWrite, s.handleManagerReplaceProduct)).Methods(PUT)
+	managersSubrouter.Handle("/products/{id:[0-9]+}", can(managers.PermProductsWrite, s.handleManagerPatchProduct)).Methods(PATCH)
+	managersSubrouter.Handle("/products/{id:[0-9]+}", can(managers.PermProductsWrite, s.handleManagerRemoveProductByID)).Methods(DELETE)
+	managersSubrouter.Handle("/products/{id:[0-9]+}/stock", can(managers.PermProductsWrite, s.handleManagerAdjustStock)).Methods(POST)
+	managersSubrouter.Handle("/products/{id:[0-9]+}/restore", can(managers.PermProductsWrite, s.handleManagerRestoreProduct)).Methods(POST)
+	managersSubrouter.Handle("/products/{id:[0-9]+}/purge", adminOnly(http.HandlerFunc(s.handleManagerPurgeProduct))).Methods(DELETE)
+	managersSubrouter.Handle("/categories", can(managers.PermProductsRead, s.handleManagerGetCategories)).Methods(GET)
+	managersSubrouter.Handle("/categories", can(managers.PermProductsWrite, s.handleManagerCreateCategory)).Methods(POST)
+	managersSubrouter.Handle("/tax-classes", can(managers.PermProductsRead, s.handleManagerGetTaxClasses)).Methods(GET)
+	managersSubrouter.Handle("/tax-classes", can(managers.PermProductsWrite, s.handleManagerCreateTaxClass)).Methods(POST)
+	managersSubrouter.Handle("/tax-classes/{id:[0-9]+}", can(managers.PermProductsWrite, s.handleManagerChangeTaxClass)).Methods(PUT)
+	managersSubrouter.Handle("/rates", can(managers.PermProductsRead, s.handleManagerGetExchangeRates)).Methods(GET)
+	managersSubrouter.Handle("/rates/{currency:[A-Za-z]{3}}", can(managers.PermProductsWrite, s.handleManagerSetExchangeRate)).Methods(PUT)
+	managersSubrouter.Handle("/promotions", can(managers.PermPromotionsRead, s.handleManagerGetPromotions)).Methods(GET)
+	managersSubrouter.Handle("/promotions", can(managers.PermPromotionsWrite, s.handleManagerCreatePromotion)).Methods(POST)
+	managersSubrouter.Handle("/promotions/{id:[0-9]+}", can(managers.PermPromotionsWrite, s.handleManagerChangePromotion)).Methods(PUT)
+	managersSubrouter.Handle("/promotions/{id:[0-9]+}", can(managers.PermPromotionsWrite, s.handleManagerRemovePromotion)).Methods(DELETE)
+	managersSubrouter.Handle("/customers", can(managers.PermCustomersRead, s.handleManagerGetCustomers)).Methods(GET)
+	managersSubrouter.Handle("/customers", can(managers.PermCustomersWrite, s.handleManagerCreateCustomer)).Methods(POST)
+	managersSubrouter.Handle("/customers/{id:[0-9]+}", can(managers.PermCustomersRead, s.handleManagerGetCustomerByID)).Methods(GET)
+	managersSubrouter.Handle("/customers/{id:[0-9]+}", can(managers.PermCustomersWrite, s.handleManagerReplaceCustomer)).Methods(PUT)
+	managersSubrouter.Handle("/customers/{id:[0-9]+}", can(managers.PermCustomersWrite, s.handleManagerPatchCustomer)).Methods(PATCH)
+	managersSubrouter.Handle("/customers/{id:[0-9]+}", can(managers.PermCustomersDelete, s.handleManagerRemoveCustomerByID)).Methods(DELETE)
+	managersSubrouter.Handle("/customers/{id:[0-9]+}/restore", can(managers.PermCustomersDelete, s.handleManagerRestoreCustomer)).Methods(POST)
+	managersSubrouter.Handle("/customers/{id:[0-9]+}/purge", adminOnly(http.HandlerFunc(s.handleManagerPurgeCustomer))).Methods(DELETE)
+	managersSubrouter.Handle("/customers/{id:[0-9]+}/lock", adminOnly(http.HandlerFunc(s.handleManagerUnlockCustomer))).Methods(DELETE)
+
+}
+
+// clientInfo - information about the client which made the request.
+func clientInfo(request *http.Request) *types.Client {
+	ip, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		ip = request.RemoteAddr
+	}
+
+	return &types.Client{
+		UserAgent: request.UserAgent(),
+		IP:        ip,
+	}
+}
+
+// productFilter - reads the conditions of products search from query parameters.
+func productFilter(query url.Values) (*types.ProductFilter, error) {
+	filter := &types.ProductFilter{
+		Query:  query.Get("q"),
+		Sort:   query.Get("sort"),
+		Cursor: query.Get("cursor"),
+		Limit:  50,
+	}
+
+	if value := query.Get("category_id"); value != "" {
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		filter.CategoryID = id
+	}
+
+	prices := map[string]**int64{
+		"min_price": &filter.MinPrice,
+		"max_price": &filter.MaxPrice,
+	}
+	for name, price := range prices {
+		if value := query.Get(name); value != "" {
+			number, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			*price = &number
+		}
+	}
+
+	if value := query.Get("in_stock"); value != "" {
+		inStock, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, err
+		}
+		filter.InStock = inStock
+	}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > 500 {
+			return nil, fmt.Errorf("invalid limit: %q", value)
+		}
+		filter.Limit = limit
+	}
+
+	return filter, nil
+}
+
+// respondProducts - response with the page of found products, the count of all found products
+// is sent in X-Total-Count header and the cursor of the next page in X-Next-Cursor header.
+func respondProducts(writer http.ResponseWriter, items interface{}, page *types.Page, err error) {
+	if errors.Is(err, catalog.ErrInvalidSort) || errors.Is(err, catalog.ErrInvalidCursor) {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	writer.Header().Set("X-Total-Count", strconv.FormatInt(page.Total, 10))
+	if page.Next != "" {
+		writer.Header().Set("X-Next-Cursor", page.Next)
+	}
+	respondJSON(writer, items)
+}
+
+// respondUnauthorized - response when handler of protected route has no authenticated user.
+func respondUnauthorized(writer http.ResponseWriter) {
+	middleware.RespondUnauthorized(writer, middleware.ReasonTokenMissing)
+}
+
+// respondLocked - response when logins are locked after too many failures.
+func respondLocked(writer http.ResponseWriter, err *logins.LockedError) {
+	retry := int(time.Until(err.Until).Seconds()) + 1
+	writer.Header().Set("Retry-After", strconv.Itoa(retry))
+	http.Error(writer, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+}
 
+// respondValidationError - response with the reason of failed validation.
+func respondValidationError(writer http.ResponseWriter, item *types.ValidationError) {
+	data, err := json.Marshal(item)
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusUnprocessableEntity)
+	_, err = writer.Write(data)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// respondInsufficientStock - response when product hasn't enough quantity for the sale.
+func respondInsufficientStock(writer http.ResponseWriter, item *sales.InsufficientStockError) {
+	data, err := json.Marshal(map[string]interface{}{
+		"error":      "insufficient_stock",
+		"product_id": item.ProductID,
+		"requested":  item.Requested,
+		"available":  item.Available,
+	})
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusConflict)
+	_, err = writer.Write(data)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// respondReceipt - response with the receipt in the format from query, text by default.
+func (s *Server) respondReceipt(writer http.ResponseWriter, request *http.Request, receipt *receipts.Receipt, err error) {
+	if errors.Is(err, receipts.ErrNotFound) {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	format := request.URL.Query().Get("format")
+	if format == "" {
+		format = receipts.FormatText
+	}
+
+	var buf bytes.Buffer
+	err = s.receiptsSvc.Render(&buf, format, receipt)
+	if errors.Is(err, receipts.ErrUnknownFormat) {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	writer.Header().Set("Content-Type", receipts.ContentType(format))
+	if format == receipts.FormatPDF {
+		writer.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="receipt-%d.pdf"`, receipt.Sale.ID))
+	}
+	_, err = buf.WriteTo(writer)
+	if err != nil {
+		log.Println(err)
+	}
 }
 
 // respondJSON - response from JSON.
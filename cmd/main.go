@@ -13,8 +13,14 @@ import (
 	"go.uber.org/dig"
 
 	"github.com/SardorMS/CRUD/cmd/app"
+	"github.com/SardorMS/CRUD/pkg/config"
 	"github.com/SardorMS/CRUD/pkg/customers"
+	"github.com/SardorMS/CRUD/pkg/idempotency"
+	"github.com/SardorMS/CRUD/pkg/logins"
 	"github.com/SardorMS/CRUD/pkg/managers"
+	"github.com/SardorMS/CRUD/pkg/receipts"
+	"github.com/SardorMS/CRUD/pkg/sms"
+	"github.com/SardorMS/CRUD/pkg/tokens"
 )
 
 func main() {
@@ -36,11 +42,23 @@ func execute(host string, port string, dsn string) (err error) {
 		app.NewServer,
 		mux.NewRouter,
 		func() (*pgxpool.Pool, error) {
-			ctx, _ := context.WithTimeout(context.Background(), time.Second*5)
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+			defer cancel()
 			return pgxpool.Connect(ctx, dsn)
 		},
+		config.FromEnv,
+		func(cfg *config.Config) sms.Sender {
+			if cfg.SMSFile != "" {
+				return sms.NewFileSender(cfg.SMSFile)
+			}
+			return sms.LogSender{}
+		},
+		logins.NewService,
+		tokens.NewService,
 		customers.NewService,
 		managers.NewService,
+		receipts.NewService,
+		idempotency.NewService,
 		//managers.NewService,
 		//products.NewService,
 		//sales.NewService,
@@ -1,8 +1,11 @@
 
 -- Insert Examples.
 
-INSERT INTO managers (name, phone, password, is_admin)
-VALUES ('vasya', '+992000000001', '$2a$10$OaUtjCNv2DT5x/dXcV.P3eYkIPIRtBr/v8Nluwifz6brSkfyXOh6m', true);
+INSERT INTO managers (name, phone, password)
+VALUES ('vasya', '+992000000001', '$2a$10$OaUtjCNv2DT5x/dXcV.P3eYkIPIRtBr/v8Nluwifz6brSkfyXOh6m');
+
+INSERT INTO managers_roles (manager_id, role)
+VALUES (1, 'ADMIN'), (1, 'MANAGER');
 
 INSERT INTO products (name, price, qty)
 VALUES ('Pizza', 200, 10);
@@ -19,6 +22,7 @@ ALTER SEQUENCE products_id_seq RESTART WITH 1;
 ALTER SEQUENCE sales_id_seq RESTART WITH 1;
 ALTER SEQUENCE sale_positions_id_seq RESTART WITH 1;
 ALTER SEQUENCE managers_id_seq RESTART WITH 1;
+DELETE FROM managers_roles;
 DELETE FROM managers;
 DELETE FROM customers;
 DELETE FROM customers_tokens;
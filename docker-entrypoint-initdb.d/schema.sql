@@ -1,12 +1,18 @@
+-- Trigram similarity for search of products by words with typos.
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
 -- Table of registred customers.
 CREATE TABLE IF NOT EXISTS customers
 (
-    id       BIGSERIAL PRIMARY KEY,
-    name     TEXT      NOT NULL,
-    phone    TEXT      NOT NULL UNIQUE,
-    password TEXT      NOT NULL,
-    active   BOOLEAN   NOT NULL DEFAULT TRUE, 
-    created  TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+    id         BIGSERIAL PRIMARY KEY,
+    name       TEXT      NOT NULL,
+    phone      TEXT      NOT NULL UNIQUE,
+    password   TEXT      NOT NULL,
+    credit     BIGINT    NOT NULL DEFAULT 0 CHECK (credit >= 0), -- store credit, can be used to pay.
+    active     BOOLEAN   NOT NULL DEFAULT TRUE, 
+    version    BIGINT    NOT NULL DEFAULT 1,                  -- changed with each change of customer.
+    deleted_at TIMESTAMP,                                     -- set when customer is archived.
+    created    TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
 );
 
 -- Table of managers.
@@ -20,38 +26,223 @@ CREATE TABLE IF NOT EXISTS managers
     plan       INTEGER   NOT NULL DEFAULT 0 ,
     boss_id    BIGINT    REFERENCES managers,
     department TEXT,
-    is_admin   BOOLEAN   NOT NULL DEFAULT TRUE,
     active     BOOLEAN   NOT NULL DEFAULT TRUE, 
     created    TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
 );
 
+-- Table of roles.
+CREATE TABLE IF NOT EXISTS roles
+(
+    name        TEXT      PRIMARY KEY,
+    description TEXT      NOT NULL DEFAULT '',
+    created     TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Table of permissions granted by roles.
+CREATE TABLE IF NOT EXISTS roles_permissions
+(
+    role       TEXT NOT NULL REFERENCES roles ON DELETE CASCADE ON UPDATE CASCADE,
+    permission TEXT NOT NULL,
+    PRIMARY KEY (role, permission)
+);
+
+-- Built-in roles.
+INSERT INTO roles (name, description)
+VALUES ('ADMIN', 'Administrator'), ('MANAGER', 'Manager')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO roles_permissions (role, permission)
+VALUES ('ADMIN', 'products:read'), ('ADMIN', 'products:write'),
+       ('ADMIN', 'customers:read'), ('ADMIN', 'customers:write'), ('ADMIN', 'customers:delete'),
+       ('ADMIN', 'sales:read'), ('ADMIN', 'sales:read:all'), ('ADMIN', 'sales:write'), ('ADMIN', 'sales:price:override'),
+       ('ADMIN', 'sales:void'),
+       ('ADMIN', 'reports:export'), ('ADMIN', 'managers:write'),
+       ('ADMIN', 'promotions:read'), ('ADMIN', 'promotions:write'),
+       ('MANAGER', 'products:read'), ('MANAGER', 'products:write'),
+       ('MANAGER', 'customers:read'), ('MANAGER', 'customers:write'),
+       ('MANAGER', 'sales:read'), ('MANAGER', 'sales:write'), ('MANAGER', 'sales:void'), ('MANAGER', 'promotions:read')
+ON CONFLICT DO NOTHING;
+
+-- Table of managers roles.
+CREATE TABLE IF NOT EXISTS managers_roles
+(
+    manager_id BIGINT    NOT NULL REFERENCES managers,
+    role       TEXT      NOT NULL REFERENCES roles ON DELETE CASCADE ON UPDATE CASCADE,
+    created    TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (manager_id, role)
+);
+
+-- Table of one-time codes to set up the password of managers.
+CREATE TABLE IF NOT EXISTS managers_setup_codes
+(
+    id         BIGSERIAL PRIMARY KEY,
+    code       TEXT      NOT NULL UNIQUE,
+    manager_id BIGINT    NOT NULL REFERENCES managers,
+    used       BOOLEAN   NOT NULL DEFAULT FALSE,
+    expire     TIMESTAMP NOT NULL,
+    created    TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
 -- Table of customers tokens.
 CREATE TABLE IF NOT EXISTS customers_tokens 
 (
-    token        TEXT      NOT NULL UNIQUE,
-    customer_id  BIGINT    NOT NULL REFERENCES customers,
-    expire       TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP + INTERVAL '1 hour',
-    created      TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP 
+    id             BIGSERIAL PRIMARY KEY,
+    token          TEXT      NOT NULL UNIQUE,
+    customer_id    BIGINT    NOT NULL REFERENCES customers,
+    expire         TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP + INTERVAL '1 hour',
+    refresh        TEXT      NOT NULL UNIQUE,
+    refresh_expire TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP + INTERVAL '7 days',
+    user_agent     TEXT      NOT NULL DEFAULT '',
+    ip             TEXT      NOT NULL DEFAULT '',
+    last_used      TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    created        TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP 
+);
+
+-- Table of one-time codes to reset the password of customers.
+CREATE TABLE IF NOT EXISTS customers_reset_codes
+(
+    id          BIGSERIAL PRIMARY KEY,
+    customer_id BIGINT    NOT NULL REFERENCES customers,
+    phone       TEXT      NOT NULL,
+    code        TEXT      NOT NULL,
+    attempts    INTEGER   NOT NULL DEFAULT 0,
+    used        BOOLEAN   NOT NULL DEFAULT FALSE,
+    expire      TIMESTAMP NOT NULL,
+    created     TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
 );
 
 -- Table of managers tokens.
 CREATE TABLE IF NOT EXISTS managers_tokens 
 (
-    token        TEXT      NOT NULL UNIQUE,
-    manager_id   BIGINT    NOT NULL REFERENCES managers,
-    expire       TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP + INTERVAL '1 hour',
-    created      TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP 
+    id             BIGSERIAL PRIMARY KEY,
+    token          TEXT      NOT NULL UNIQUE,
+    manager_id     BIGINT    NOT NULL REFERENCES managers,
+    expire         TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP + INTERVAL '1 hour',
+    refresh        TEXT      NOT NULL UNIQUE,
+    refresh_expire TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP + INTERVAL '7 days',
+    user_agent     TEXT      NOT NULL DEFAULT '',
+    ip             TEXT      NOT NULL DEFAULT '',
+    last_used      TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    created        TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP 
+);
+
+-- Table of revoked signed tokens (deny-list).
+CREATE TABLE IF NOT EXISTS revoked_tokens
+(
+    jti     TEXT      PRIMARY KEY,
+    expire  TIMESTAMP NOT NULL,
+    created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Table of failed logins counters (by phone or ip).
+CREATE TABLE IF NOT EXISTS login_failures
+(
+    kind         TEXT      NOT NULL,
+    scope        TEXT      NOT NULL CHECK (scope IN ('phone', 'ip')),
+    key          TEXT      NOT NULL,
+    failures     INTEGER   NOT NULL DEFAULT 0,
+    locked_until TIMESTAMP,
+    updated      TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (kind, scope, key)
+);
+
+-- Table of login attempts.
+CREATE TABLE IF NOT EXISTS login_history
+(
+    id         BIGSERIAL PRIMARY KEY,
+    kind       TEXT      NOT NULL,
+    phone      TEXT      NOT NULL,
+    user_id    BIGINT,
+    success    BOOLEAN   NOT NULL,
+    ip         TEXT      NOT NULL DEFAULT '',
+    user_agent TEXT      NOT NULL DEFAULT '',
+    created    TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Table of categories of products, subcategories refer to the parent category.
+CREATE TABLE IF NOT EXISTS categories
+(
+    id        BIGSERIAL PRIMARY KEY,
+    parent_id BIGINT    REFERENCES categories, -- NULL for top level categories.
+    name      TEXT      NOT NULL,
+    created   TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Names of categories are unique within the parent category.
+CREATE UNIQUE INDEX IF NOT EXISTS categories_parent_name_key ON categories ((COALESCE (parent_id, 0)), name);
+
+-- Table of tax classes of products, rate is in basis points (1200 - 12%).
+CREATE TABLE IF NOT EXISTS tax_classes
+(
+    id        BIGSERIAL PRIMARY KEY,
+    name      TEXT      NOT NULL UNIQUE,
+    rate      INTEGER   NOT NULL CHECK (rate >= 0),
+    inclusive BOOLEAN   NOT NULL DEFAULT TRUE, -- tax is included in the price, otherwise it's added to it.
+    created   TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
 );
 
 -- Table of products.
 CREATE TABLE IF NOT EXISTS products 
 (
-    id      BIGSERIAL PRIMARY KEY,
-    name    TEXT      NOT NULL,
-    price   INTEGER   NOT NULL CHECK (price > 0),
-    qty     INTEGER   NOT NULL DEFAULT 0 CHECK (qty >= 0),
-    active  BOOLEAN   NOT NULL DEFAULT TRUE, 
-    created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+    id           BIGSERIAL PRIMARY KEY,
+    name         TEXT      NOT NULL,
+    category_id  BIGINT    REFERENCES categories,
+    tax_class_id BIGINT    REFERENCES tax_classes, -- NULL when product isn't taxed.
+    sku          TEXT      UNIQUE,                 -- stock keeping unit, NULL if not set.
+    barcode      TEXT      UNIQUE,                 -- EAN/UPC code, NULL if not set.
+    description  TEXT      NOT NULL DEFAULT '',
+    unit         TEXT      NOT NULL DEFAULT 'pcs',
+    price        BIGINT    NOT NULL CHECK (price > 0),
+    qty          INTEGER   NOT NULL DEFAULT 0 CHECK (qty >= 0),
+    active       BOOLEAN   NOT NULL DEFAULT TRUE, 
+    version      BIGINT    NOT NULL DEFAULT 1,   -- changed with each change of product.
+    deleted_at   TIMESTAMP,                      -- set when product is archived.
+    created      TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Full-text search of products, the expression must be the same as in pkg/catalog.
+CREATE INDEX IF NOT EXISTS products_search_idx
+    ON products USING GIN (to_tsvector('simple', name || ' ' || description || ' ' || COALESCE (sku, '')));
+CREATE INDEX IF NOT EXISTS products_name_trgm_idx ON products USING GIN (name gin_trgm_ops);
+
+-- Table of prices of products in other currencies than the base one,
+-- products without own price are sold by exchange rate.
+CREATE TABLE IF NOT EXISTS product_prices
+(
+    product_id BIGINT    NOT NULL REFERENCES products ON DELETE CASCADE,
+    currency   TEXT      NOT NULL,
+    price      BIGINT    NOT NULL CHECK (price > 0),
+    created    TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (product_id, currency)
+);
+
+-- Table of exchange rates: price of one unit of the currency in units of the base currency.
+CREATE TABLE IF NOT EXISTS exchange_rates
+(
+    currency TEXT      PRIMARY KEY,
+    rate     NUMERIC   NOT NULL CHECK (rate > 0),
+    updated  TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Table of promotions: discounts applied to sales automatically or by promo code.
+-- Scope is the product, the category or, if both are NULL, all products.
+CREATE TABLE IF NOT EXISTS promotions
+(
+    id          BIGSERIAL PRIMARY KEY,
+    name        TEXT      NOT NULL,
+    kind        TEXT      NOT NULL CHECK (kind IN ('percent', 'fixed', 'buy_get')),
+    value       BIGINT    NOT NULL DEFAULT 0 CHECK (value >= 0), -- percents or amount off each unit.
+    buy         INTEGER   NOT NULL DEFAULT 0 CHECK (buy >= 0),   -- buy_get: paid units,
+    get         INTEGER   NOT NULL DEFAULT 0 CHECK (get >= 0),   -- and free units.
+    product_id  BIGINT    REFERENCES products,
+    category_id BIGINT    REFERENCES categories,
+    code        TEXT      UNIQUE,                                -- NULL when applied automatically.
+    usage_limit INTEGER   CHECK (usage_limit > 0),               -- sales by the code, NULL - unlimited.
+    used        INTEGER   NOT NULL DEFAULT 0,
+    starts      TIMESTAMP,
+    ends        TIMESTAMP,
+    active      BOOLEAN   NOT NULL DEFAULT TRUE,
+    created     TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
 );
 
 
@@ -59,23 +250,122 @@ CREATE TABLE IF NOT EXISTS products
 CREATE TABLE IF NOT EXISTS sales
 (
     id          BIGSERIAL PRIMARY KEY,
-    manager_id  BIGINT    NOT NULL REFERENCES managers,
+    manager_id  BIGINT    REFERENCES managers, -- NULL when customer checks out the cart.
     customer_id BIGINT    NOT NULL,
+    status      TEXT      NOT NULL DEFAULT 'pending'
+                CHECK (status IN ('pending', 'paid', 'partially_refunded', 'refunded', 'voided')),
+    currency    TEXT      NOT NULL,                  -- currency of prices and payments of the sale.
+    rate        NUMERIC   NOT NULL DEFAULT 1,        -- minor units of base currency for one minor unit of currency.
     created     TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
 );
 
 -- Table about sales positions (cheque).
 CREATE TABLE IF NOT EXISTS sale_positions
+(
+    id            BIGSERIAL PRIMARY KEY,
+    sale_id       BIGINT    NOT NULL REFERENCES sales,
+    product_id    BIGINT    NOT NULL REFERENCES products,
+    price         BIGINT    NOT NULL CHECK (price >= 0),
+    qty           INTEGER   NOT NULL DEFAULT 0 CHECK (qty >= 0),
+    discount      BIGINT    NOT NULL DEFAULT 0 CHECK (discount >= 0), -- discount of the whole position.
+    promotion_id  BIGINT    REFERENCES promotions,                    -- promotion which gave the discount.
+    tax           BIGINT    NOT NULL DEFAULT 0 CHECK (tax >= 0),      -- tax of the whole position after discount.
+    tax_rate      INTEGER   NOT NULL DEFAULT 0,                       -- rate of tax class at the moment of sale.
+    tax_inclusive BOOLEAN   NOT NULL DEFAULT TRUE,                    -- tax is included in the price, otherwise it's added.
+    created       TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+
+-- Table of payments of sales, sale can be paid by several methods.
+CREATE TABLE IF NOT EXISTS payments
 (
     id          BIGSERIAL PRIMARY KEY,
     sale_id     BIGINT    NOT NULL REFERENCES sales,
+    method      TEXT      NOT NULL CHECK (method IN ('cash', 'card', 'bank_transfer', 'store_credit')),
+    amount      BIGINT    NOT NULL CHECK (amount > 0),    -- paid for the sale.
+    tendered    BIGINT    NOT NULL CHECK (tendered > 0),  -- given by customer, more than amount only in cash.
+    change      BIGINT    NOT NULL DEFAULT 0 CHECK (change >= 0),
+    manager_id  BIGINT    REFERENCES managers,
+    created     TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Table of prices of positions changed by managers.
+CREATE TABLE IF NOT EXISTS price_overrides
+(
+    id             BIGSERIAL PRIMARY KEY,
+    sale_id        BIGINT    NOT NULL REFERENCES sales,
+    position_id    BIGINT    NOT NULL REFERENCES sale_positions,
+    product_id     BIGINT    NOT NULL REFERENCES products,
+    manager_id     BIGINT    NOT NULL REFERENCES managers,
+    original_price BIGINT    NOT NULL,
+    price          BIGINT    NOT NULL,
+    created        TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Table of returns of sold products.
+CREATE TABLE IF NOT EXISTS sale_returns
+(
+    id          BIGSERIAL PRIMARY KEY,
+    sale_id     BIGINT    NOT NULL REFERENCES sales,
+    manager_id  BIGINT    NOT NULL REFERENCES managers,
+    amount      BIGINT    NOT NULL CHECK (amount >= 0),
+    reason      TEXT      NOT NULL DEFAULT '',
+    created     TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Table of returned positions, price is copied from the sold position.
+CREATE TABLE IF NOT EXISTS sale_return_positions
+(
+    id          BIGSERIAL PRIMARY KEY,
+    return_id   BIGINT    NOT NULL REFERENCES sale_returns,
+    position_id BIGINT    NOT NULL REFERENCES sale_positions,
     product_id  BIGINT    NOT NULL REFERENCES products,
-    price       INTEGER   NOT NULL CHECK (price >= 0),
-    qty         INTEGER   NOT NULL DEFAULT 0 CHECK (qty >= 0),
+    price       BIGINT    NOT NULL CHECK (price >= 0),
+    qty         INTEGER   NOT NULL CHECK (qty > 0),
+    amount      BIGINT    NOT NULL CHECK (amount >= 0), -- refund, discount of the position is taken into account.
+    tax         BIGINT    NOT NULL DEFAULT 0,           -- tax of the refund.
+    created     TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Table of money given back by the methods of payments when sale is voided or products are returned.
+CREATE TABLE IF NOT EXISTS refunds
+(
+    id          BIGSERIAL PRIMARY KEY,
+    sale_id     BIGINT    NOT NULL REFERENCES sales,
+    payment_id  BIGINT    NOT NULL REFERENCES payments, -- payment which is given back.
+    return_id   BIGINT    REFERENCES sale_returns,      -- NULL when the sale is voided.
+    method      TEXT      NOT NULL,
+    amount      BIGINT    NOT NULL CHECK (amount > 0),
+    manager_id  BIGINT    REFERENCES managers,
     created     TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
 );
 
 
+-- Table of products in the carts of customers.
+CREATE TABLE IF NOT EXISTS customers_cart
+(
+    customer_id BIGINT    NOT NULL REFERENCES customers,
+    product_id  BIGINT    NOT NULL REFERENCES products,
+    qty         INTEGER   NOT NULL CHECK (qty > 0),
+    created     TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (customer_id, product_id)
+);
+
+
+-- Table of idempotency keys of requests with the stored responses,
+-- status is NULL while the first request is being processed.
+CREATE TABLE IF NOT EXISTS idempotency_keys
+(
+    scope        TEXT      NOT NULL, -- method, path and user of the request.
+    key          TEXT      NOT NULL,
+    hash         TEXT      NOT NULL, -- hash of the request body.
+    status       INTEGER,
+    content_type TEXT      NOT NULL DEFAULT '',
+    body         BYTEA     NOT NULL DEFAULT '',
+    created      TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (scope, key)
+);
+
 -- Table of users (when a single table is used for storage).
 CREATE TABLE IF NOT EXISTS users
 (
@@ -92,9 +382,28 @@ CREATE TABLE IF NOT EXISTS users
 
 --DROP TABLE <name_of_table> CASCADE
 --DROP TABLE products;
+--DROP TABLE categories;
+--DROP TABLE tax_classes;
+--DROP TABLE product_prices;
+--DROP TABLE exchange_rates;
+--DROP TABLE promotions;
 --DROP TABLE managers;
 --DROP TABLE managers_tokens;
+--DROP TABLE managers_roles;
+--DROP TABLE managers_setup_codes;
+--DROP TABLE roles_permissions;
+--DROP TABLE roles;
 --DROP TABLE customers;
 --DROP TABLE customers_tokens;
+--DROP TABLE customers_reset_codes;
 --DROP TABLE sales;
 --DROP TABLE sale_positions;
+--DROP TABLE customers_cart;
+--DROP TABLE sale_returns;
+--DROP TABLE price_overrides;
+--DROP TABLE payments;
+--DROP TABLE sale_return_positions;
+--DROP TABLE login_failures;
+--DROP TABLE revoked_tokens;
+--DROP TABLE login_history;
+--DROP TABLE idempotency_keys;
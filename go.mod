@@ -3,12 +3,11 @@ module github.com/SardorMS/CRUD
 go 1.16
 
 require (
-	github.com/gorilla/mux v1.8.0 // indirect
-	github.com/jackc/chunkreader/v2 v2.0.1 // indirect
+	github.com/gorilla/mux v1.8.0
+	github.com/jackc/pgconn v1.8.1
 	github.com/jackc/pgproto3/v2 v2.1.0 // indirect
-	github.com/jackc/pgx v3.6.2+incompatible
-	github.com/jackc/pgx/v4 v4.11.0 // indirect
-	go.uber.org/dig v1.11.0 // indirect
-	golang.org/x/crypto v0.0.0-20210616213533-5ff15b29337e // indirect
+	github.com/jackc/pgx/v4 v4.11.0
+	go.uber.org/dig v1.11.0
+	golang.org/x/crypto v0.0.0-20210616213533-5ff15b29337e
 	golang.org/x/text v0.3.6 // indirect
 )
@@ -0,0 +1,200 @@
+package catalog
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SardorMS/CRUD/pkg/types"
+)
+
+var (
+	ErrInvalidSort   = errors.New("invalid sort")   // return when products are ordered by unknown field.
+	ErrInvalidCursor = errors.New("invalid cursor") // return when cursor isn't made by the same search.
+)
+
+// document - text of product matched by full-text search, must be the same as in the index.
+const document = `to_tsvector('simple', name || ' ' || description || ' ' || COALESCE (sku, ''))`
+
+// defaultLimit - products on the page when the limit isn't given.
+const defaultLimit = 50
+
+// sortKey - expression by which products are ordered and its type.
+type sortKey struct {
+	expr string
+	typ  string
+}
+
+// sortKeys - allowed orders of products, relevance is known only when there is a query.
+var sortKeys = map[string]sortKey{
+	"id":      {"id", "BIGINT"},
+	"name":    {"name", "TEXT"},
+	"price":   {"price", "BIGINT"},
+	"qty":     {"qty", "INTEGER"},
+	"created": {"created", "TIMESTAMP"},
+}
+
+// CategoryTree - ids of the category by the parameter and all its subcategories.
+func CategoryTree(param string) string {
+	return `WITH RECURSIVE tree AS (
+				SELECT id FROM categories WHERE id = ` + param + `
+				UNION ALL
+				SELECT c.id FROM categories c JOIN tree t ON c.parent_id = t.id
+			)
+			SELECT id FROM tree`
+}
+
+// Query - search of products by filter, built once for both customers and managers.
+type Query struct {
+	SQL       string // page of products, the key of cursor is selected after the columns.
+	Args      []interface{}
+	CountSQL  string // count of all found products.
+	CountArgs []interface{}
+	Limit     int
+	sort      string
+}
+
+// cursor - position after the last product of the page.
+type cursor struct {
+	Sort string `json:"s"`
+	Key  string `json:"k"`
+	ID   int64  `json:"i"`
+}
+
+// NewQuery - builds the search of products (and archived ones if asked) selecting the columns. Products are found by
+// full-text search, words with typos are matched by trigram similarity. Results are sorted by
+// relevance if there is a query, otherwise by id, and are paged by keyset: the page starts after
+// the product of cursor, so pages don't shift when products are added.
+func NewQuery(columns string, filter *types.ProductFilter) (*Query, error) {
+	conditions := []string{"deleted_at IS NULL"}
+	if filter.Archived {
+		conditions[0] = "TRUE"
+	}
+	args := make([]interface{}, 0)
+	param := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if filter.Active != nil {
+		conditions = append(conditions, "active = "+param(*filter.Active))
+	}
+
+	keys := sortKeys
+	sort := "id:asc"
+	if query := strings.TrimSpace(filter.Query); query != "" {
+		q := param(query)
+		conditions = append(conditions, fmt.Sprintf("(%s @@ plainto_tsquery('simple', %s) OR name %% %s)", document, q, q))
+
+		keys = make(map[string]sortKey, len(sortKeys)+1)
+		for name, key := range sortKeys {
+			keys[name] = key
+		}
+		keys["relevance"] = sortKey{
+			expr: fmt.Sprintf("ROUND ((ts_rank (%s, plainto_tsquery('simple', %s)) + similarity (name, %s))::NUMERIC, 6)", document, q, q),
+			typ:  "NUMERIC",
+		}
+		sort = "relevance:desc"
+	}
+	if filter.CategoryID != 0 {
+		conditions = append(conditions, "category_id IN ("+CategoryTree(param(filter.CategoryID))+")")
+	}
+	if filter.MinPrice != nil {
+		conditions = append(conditions, "price >= "+param(*filter.MinPrice))
+	}
+	if filter.MaxPrice != nil {
+		conditions = append(conditions, "price <= "+param(*filter.MaxPrice))
+	}
+	if filter.InStock {
+		conditions = append(conditions, "qty > 0")
+	}
+
+	if filter.Sort != "" {
+		sort = filter.Sort
+		if !strings.Contains(sort, ":") {
+			sort += ":asc"
+		}
+	}
+	parts := strings.SplitN(sort, ":", 2)
+	key, ok := keys[parts[0]]
+	if !ok || parts[1] != "asc" && parts[1] != "desc" {
+		return nil, ErrInvalidSort
+	}
+
+	item := &Query{
+		CountSQL:  `SELECT COUNT (*) FROM products WHERE ` + strings.Join(conditions, " AND ") + `;`,
+		CountArgs: append([]interface{}{}, args...),
+		Limit:     filter.Limit,
+		sort:      sort,
+	}
+	if item.Limit <= 0 {
+		item.Limit = defaultLimit
+	}
+
+	if filter.Cursor != "" {
+		after, err := decodeCursor(filter.Cursor)
+		if err != nil || after.Sort != sort || !fits(key.typ, after.Key) {
+			return nil, ErrInvalidCursor
+		}
+
+		operator := ">"
+		if parts[1] == "desc" {
+			operator = "<"
+		}
+		conditions = append(conditions, fmt.Sprintf("(%s, id) %s (%s::%s, %s::BIGINT)",
+			key.expr, operator, param(after.Key), key.typ, param(after.ID)))
+	}
+
+	// one more product is selected to know that there is the next page.
+	direction := strings.ToUpper(parts[1])
+	item.SQL = fmt.Sprintf(`SELECT %s, (%s)::TEXT FROM products WHERE %s ORDER BY %s %s, id %s LIMIT %s;`,
+		columns, key.expr, strings.Join(conditions, " AND "), key.expr, direction, direction, param(item.Limit+1))
+	item.Args = args
+	return item, nil
+}
+
+// Cursor - returns the cursor of the page which starts after the product with the key and id.
+func (q *Query) Cursor(key string, id int64) string {
+	data, _ := json.Marshal(&cursor{Sort: q.sort, Key: key, ID: id})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor - reads the cursor made by Cursor.
+func decodeCursor(text string) (*cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(text)
+	if err != nil {
+		return nil, err
+	}
+
+	item := &cursor{}
+	err = json.Unmarshal(data, item)
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// fits - reports whether the key of cursor can be cast to the type of sort, so a changed
+// cursor is refused here instead of failing in the database.
+func fits(typ string, key string) bool {
+	switch typ {
+	case "BIGINT":
+		_, err := strconv.ParseInt(key, 10, 64)
+		return err == nil
+	case "INTEGER":
+		_, err := strconv.ParseInt(key, 10, 32)
+		return err == nil
+	case "NUMERIC":
+		value, err := strconv.ParseFloat(key, 64)
+		return err == nil && !math.IsInf(value, 0) && !math.IsNaN(value)
+	case "TIMESTAMP":
+		_, err := time.Parse("2006-01-02 15:04:05.999999", key)
+		return err == nil
+	}
+	return true
+}
@@ -0,0 +1,190 @@
+package catalog
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/SardorMS/CRUD/pkg/types"
+)
+
+func TestCursor(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter types.ProductFilter
+		key    string
+		id     int64
+		after  string
+	}{
+		{"default", types.ProductFilter{}, "10", 10, "(id, id) > ($1::BIGINT, $2::BIGINT)"},
+		{"descending price", types.ProductFilter{Sort: "price:desc"}, "1500", 42, "(price, id) < ($1::BIGINT, $2::BIGINT)"},
+		{"name with quotes", types.ProductFilter{Sort: "name"}, `Tea "Green", 100g`, 7, "(name, id) > ($1::TEXT, $2::BIGINT)"},
+		{"created", types.ProductFilter{Sort: "created:desc"}, "2021-05-01 10:00:00.123456", 3, "(created, id) < ($1::TIMESTAMP, $2::BIGINT)"},
+		{"relevance", types.ProductFilter{Query: "tea"}, "0.607927", 5, "ROUND ((ts_rank ("},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			first, err := NewQuery("id", &test.filter)
+			if err != nil {
+				t.Fatalf("NewQuery() error = %v", err)
+			}
+
+			text := first.Cursor(test.key, test.id)
+			after, err := decodeCursor(text)
+			if err != nil {
+				t.Fatalf("decodeCursor(%q) error = %v", text, err)
+			}
+			if after.Sort != first.sort || after.Key != test.key || after.ID != test.id {
+				t.Errorf("decodeCursor(%q) = %+v, want sort %s, key %q and id %d", text, after, first.sort, test.key, test.id)
+			}
+
+			filter := test.filter
+			filter.Cursor = text
+			next, err := NewQuery("id", &filter)
+			if err != nil {
+				t.Fatalf("NewQuery() with cursor error = %v", err)
+			}
+			if !strings.Contains(next.SQL, test.after) {
+				t.Errorf("SQL = %s, want condition %s", next.SQL, test.after)
+			}
+			if !containsArgs(next.Args, test.key, test.id) {
+				t.Errorf("Args = %v, want key %q and id %d", next.Args, test.key, test.id)
+			}
+			if next.CountSQL != first.CountSQL {
+				t.Errorf("CountSQL of next page = %s, want %s", next.CountSQL, first.CountSQL)
+			}
+		})
+	}
+}
+
+func TestNewQueryInvalidCursor(t *testing.T) {
+	byPrice, err := NewQuery("id", &types.ProductFilter{Sort: "price"})
+	if err != nil {
+		t.Fatalf("NewQuery() error = %v", err)
+	}
+	valid := byPrice.Cursor("1500", 42)
+
+	tests := []struct {
+		name   string
+		sort   string
+		cursor string
+	}{
+		{"not base64", "price", "!!!"},
+		{"not JSON", "price", base64.RawURLEncoding.EncodeToString([]byte("price:1500:42"))},
+		{"tampered", "price", "X" + valid[1:]},
+		{"truncated", "price", valid[:len(valid)/2]},
+		{"other sort", "name", valid},
+		{"other direction", "price:desc", valid},
+		{"price not number", "price", byPrice.Cursor("1500 OR 1=1", 42)},
+		{"qty out of range", "qty", cursorOf(t, "qty", "9999999999")},
+		{"created not time", "created", cursorOf(t, "created", "yesterday")},
+		{"relevance not number", "relevance", cursorOf(t, "relevance", "NaN")},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewQuery("id", &types.ProductFilter{Query: "phone", Sort: test.sort, Cursor: test.cursor})
+			if !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("NewQuery() with cursor %q error = %v, want %v", test.cursor, err, ErrInvalidCursor)
+			}
+		})
+	}
+}
+
+// cursorOf - returns the cursor with the key after the product 42 sorted by sort ascending.
+func cursorOf(t *testing.T, sort string, key string) string {
+	t.Helper()
+	query, err := NewQuery("id", &types.ProductFilter{Query: "phone", Sort: sort})
+	if err != nil {
+		t.Fatalf("NewQuery() error = %v", err)
+	}
+	return query.Cursor(key, 42)
+}
+
+func TestNewQueryCursorKey(t *testing.T) {
+	tests := []struct {
+		sort string
+		key  string
+	}{
+		{"id", "42"},
+		{"name", "it's a name"},
+		{"price", "-1500"},
+		{"qty", "0"},
+		{"created", "2021-05-01 12:30:00.123456"},
+		{"created", "2021-05-01 12:30:00"},
+		{"relevance", "0.123456"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.sort, func(t *testing.T) {
+			_, err := NewQuery("id", &types.ProductFilter{Query: "phone", Sort: test.sort, Cursor: cursorOf(t, test.sort, test.key)})
+			if err != nil {
+				t.Errorf("NewQuery() with key %q error = %v, want nil", test.key, err)
+			}
+		})
+	}
+}
+
+func TestNewQueryOrder(t *testing.T) {
+	// the id is the last key of order, so products with equal keys keep their order on all pages.
+	tests := []struct {
+		name   string
+		filter types.ProductFilter
+		order  string
+	}{
+		{"default", types.ProductFilter{}, "ORDER BY id ASC, id ASC LIMIT $1"},
+		{"ascending", types.ProductFilter{Sort: "price:asc"}, "ORDER BY price ASC, id ASC LIMIT $1"},
+		{"ascending by default", types.ProductFilter{Sort: "name"}, "ORDER BY name ASC, id ASC LIMIT $1"},
+		{"descending", types.ProductFilter{Sort: "qty:desc"}, "ORDER BY qty DESC, id DESC LIMIT $1"},
+		{"relevance", types.ProductFilter{Query: "tea"}, "::NUMERIC, 6) DESC, id DESC LIMIT $2"},
+		{"relevance ascending", types.ProductFilter{Query: "tea", Sort: "relevance:asc"}, "::NUMERIC, 6) ASC, id ASC LIMIT $2"},
+		{"query by price", types.ProductFilter{Query: "tea", Sort: "price:desc"}, "ORDER BY price DESC, id DESC LIMIT $2"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query, err := NewQuery("id", &test.filter)
+			if err != nil {
+				t.Fatalf("NewQuery() error = %v", err)
+			}
+			if !strings.HasSuffix(query.SQL, test.order+";") {
+				t.Errorf("SQL = %s, want order %s", query.SQL, test.order)
+			}
+			if limit := query.Args[len(query.Args)-1]; limit != defaultLimit+1 {
+				t.Errorf("limit = %v, want %d", limit, defaultLimit+1)
+			}
+		})
+	}
+}
+
+func TestNewQueryInvalidSort(t *testing.T) {
+	tests := []string{"unknown", "price:up", "relevance", ":asc", "price:"}
+
+	for _, sort := range tests {
+		t.Run(sort, func(t *testing.T) {
+			_, err := NewQuery("id", &types.ProductFilter{Sort: sort})
+			if !errors.Is(err, ErrInvalidSort) {
+				t.Errorf("NewQuery() with sort %q error = %v, want %v", sort, err, ErrInvalidSort)
+			}
+		})
+	}
+}
+
+// containsArgs - checks that the args contain the values in order.
+func containsArgs(args []interface{}, values ...interface{}) bool {
+	for i := 0; i+len(values) <= len(args); i++ {
+		found := true
+		for j, value := range values {
+			if args[i+j] != value {
+				found = false
+				break
+			}
+		}
+		if found {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,173 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tokens - lifetime of the tokens issued to one kind of users.
+type Tokens struct {
+	TTL        time.Duration // lifetime of access token.
+	RefreshTTL time.Duration // lifetime of refresh token.
+}
+
+// Logins - limits of failed logins.
+type Logins struct {
+	MaxFailures      int           // failures by one phone before lock.
+	MaxFailuresPerIP int           // failures from one ip before lock.
+	Window           time.Duration // period in which failures are counted.
+	Lockout          time.Duration // lifetime of the lock.
+	Delay            time.Duration // delay after the first failure, doubled by each next one.
+}
+
+// Config - represents the application settings.
+type Config struct {
+	Customers Tokens
+	Managers  Tokens
+	Logins    Logins
+
+	TokenMode   string // opaque, hmac or ed25519.
+	TokenSecret string // HMAC secret or base64 Ed25519 seed of signed tokens.
+
+	MinPasswordLength int           // minimal length of passwords.
+	SetupCodeTTL      time.Duration // lifetime of code to set up the password of manager.
+
+	ResetCodeTTL      time.Duration // lifetime of code to reset the password of customer.
+	ResetCodeAttempts int           // attempts to enter the reset code.
+	ResetCodesPerHour int           // reset codes which can be requested for one phone per hour.
+
+	SMSFile string // file to which text messages are written, log is used if empty.
+
+	MaxPriceDeviation int // percents by which overridden price can differ from the price of product.
+
+	Currency   string   // base currency of prices, ISO 4217 code.
+	Currencies []string // other currencies of sales, empty if sales are made only in the base currency.
+
+	Store            Store
+	ReceiptTemplates string // directory with templates of receipts, built-in templates are used if empty.
+
+	IdempotencyKeyTTL time.Duration // period in which repeated requests with the same key get the stored response.
+}
+
+// Store - details of the store printed on receipts.
+type Store struct {
+	Name    string
+	Address string
+	Phone   string
+}
+
+// Default - returns the settings used when nothing is configured.
+func Default() *Config {
+	return &Config{
+		Customers: Tokens{
+			TTL:        time.Hour,
+			RefreshTTL: time.Hour * 24 * 30,
+		},
+		Managers: Tokens{
+			TTL:        time.Hour,
+			RefreshTTL: time.Hour * 24 * 7,
+		},
+		Logins: Logins{
+			MaxFailures:      5,
+			MaxFailuresPerIP: 50,
+			Window:           time.Minute * 15,
+			Lockout:          time.Minute * 15,
+			Delay:            time.Millisecond * 500,
+		},
+		TokenMode:         "opaque",
+		MinPasswordLength: 6,
+		SetupCodeTTL:      time.Hour * 72,
+		ResetCodeTTL:      time.Minute * 10,
+		ResetCodeAttempts: 5,
+		ResetCodesPerHour: 3,
+		MaxPriceDeviation: 20,
+		Currency:          "UZS",
+		Store: Store{
+			Name: "CRUD",
+		},
+		IdempotencyKeyTTL: time.Hour * 24,
+	}
+}
+
+// FromEnv - returns the default settings overridden by environment variables.
+func FromEnv() *Config {
+	cfg := Default()
+
+	cfg.Customers.TTL = duration("CUSTOMERS_TOKEN_TTL", cfg.Customers.TTL)
+	cfg.Customers.RefreshTTL = duration("CUSTOMERS_REFRESH_TOKEN_TTL", cfg.Customers.RefreshTTL)
+	cfg.Managers.TTL = duration("MANAGERS_TOKEN_TTL", cfg.Managers.TTL)
+	cfg.Managers.RefreshTTL = duration("MANAGERS_REFRESH_TOKEN_TTL", cfg.Managers.RefreshTTL)
+	cfg.Logins.MaxFailures = integer("LOGIN_MAX_FAILURES", cfg.Logins.MaxFailures)
+	cfg.Logins.MaxFailuresPerIP = integer("LOGIN_MAX_FAILURES_PER_IP", cfg.Logins.MaxFailuresPerIP)
+	cfg.Logins.Window = duration("LOGIN_FAILURES_WINDOW", cfg.Logins.Window)
+	cfg.Logins.Lockout = duration("LOGIN_LOCKOUT", cfg.Logins.Lockout)
+	cfg.Logins.Delay = duration("LOGIN_DELAY", cfg.Logins.Delay)
+	if mode, ok := os.LookupEnv("TOKEN_MODE"); ok {
+		cfg.TokenMode = mode
+	}
+	cfg.TokenSecret = os.Getenv("TOKEN_SECRET")
+	cfg.MinPasswordLength = integer("MIN_PASSWORD_LENGTH", cfg.MinPasswordLength)
+	cfg.SetupCodeTTL = duration("MANAGERS_SETUP_CODE_TTL", cfg.SetupCodeTTL)
+	cfg.ResetCodeTTL = duration("CUSTOMERS_RESET_CODE_TTL", cfg.ResetCodeTTL)
+	cfg.ResetCodeAttempts = integer("CUSTOMERS_RESET_CODE_ATTEMPTS", cfg.ResetCodeAttempts)
+	cfg.ResetCodesPerHour = integer("CUSTOMERS_RESET_CODES_PER_HOUR", cfg.ResetCodesPerHour)
+	cfg.SMSFile = os.Getenv("SMS_FILE")
+	cfg.MaxPriceDeviation = integer("PRICE_OVERRIDE_MAX_DEVIATION", cfg.MaxPriceDeviation)
+	if currency, ok := os.LookupEnv("BASE_CURRENCY"); ok {
+		cfg.Currency = strings.ToUpper(currency)
+	}
+	cfg.Currencies = list("CURRENCIES")
+	if name, ok := os.LookupEnv("STORE_NAME"); ok {
+		cfg.Store.Name = name
+	}
+	cfg.Store.Address = os.Getenv("STORE_ADDRESS")
+	cfg.Store.Phone = os.Getenv("STORE_PHONE")
+	cfg.ReceiptTemplates = os.Getenv("RECEIPT_TEMPLATES")
+	cfg.IdempotencyKeyTTL = duration("IDEMPOTENCY_KEY_TTL", cfg.IdempotencyKeyTTL)
+
+	return cfg
+}
+
+// duration - reads the duration (e.g. 15m, 2h) from environment variable.
+func duration(name string, def time.Duration) time.Duration {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return def
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Printf("invalid %s: %q, using %s", name, value, def)
+		return def
+	}
+	return d
+}
+
+// integer - reads the positive number from environment variable.
+func integer(name string, def int) int {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return def
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		log.Printf("invalid %s: %q, using %d", name, value, def)
+		return def
+	}
+	return n
+}
+
+// list - reads the comma separated list of currencies from environment variable.
+func list(name string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(os.Getenv(name), ",") {
+		if item = strings.ToUpper(strings.TrimSpace(item)); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
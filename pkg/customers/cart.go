@@ -0,0 +1,198 @@
+package customers
+
+import (
+	"context"
+	"log"
+
+	"github.com/jackc/pgx/v4"
+
+	"github.com/SardorMS/CRUD/pkg/sales"
+	"github.com/SardorMS/CRUD/pkg/types"
+)
+
+// Cart - shows the cart of customer with current prices of products.
+func (s *Service) Cart(ctx context.Context, id int64) (*types.Cart, error) {
+	sql := `SELECT c.product_id, p.name, p.price, c.qty
+			FROM customers_cart c
+			JOIN products p ON p.id = c.product_id
+			WHERE c.customer_id = $1
+			ORDER BY c.created, c.product_id;`
+	rows, err := s.pool.Query(ctx, sql, id)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	items, err := scanCart(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	cart := &types.Cart{Items: items, Total: types.NewMoney(0, s.pricing.Base)}
+	for _, item := range items {
+		item.Price.Currency = s.pricing.Base
+		item.Sum.Currency = s.pricing.Base
+		cart.Total = cart.Total.Add(item.Sum)
+	}
+	return cart, nil
+}
+
+// AddToCart - adds the active product to the cart, quantity is summed if it's already there.
+func (s *Service) AddToCart(ctx context.Context, id int64, item *types.CartItem) error {
+	if item.Qty <= 0 {
+		return &types.ValidationError{Field: "qty", Reason: "not_positive"}
+	}
+
+	sql := `INSERT INTO customers_cart (customer_id, product_id, qty)
+			SELECT $1, id, $3 FROM products WHERE id = $2 AND active AND deleted_at IS NULL
+			ON CONFLICT (customer_id, product_id) DO UPDATE SET qty = customers_cart.qty + EXCLUDED.qty;`
+	tag, err := s.pool.Exec(ctx, sql, id, item.ProductID, item.Qty)
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+
+	if tag.RowsAffected() == 0 {
+		return &types.ValidationError{Field: "product_id", Reason: "not_found"}
+	}
+	return nil
+}
+
+// ChangeCartItem - sets the quantity of product in the cart.
+func (s *Service) ChangeCartItem(ctx context.Context, id int64, productID int64, qty int) error {
+	if qty <= 0 {
+		return &types.ValidationError{Field: "qty", Reason: "not_positive"}
+	}
+
+	sql := `UPDATE customers_cart SET qty = $3 WHERE customer_id = $1 AND product_id = $2;`
+	tag, err := s.pool.Exec(ctx, sql, id, productID, qty)
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// RemoveFromCart - removes the product from the cart.
+func (s *Service) RemoveFromCart(ctx context.Context, id int64, productID int64) error {
+	sql := `DELETE FROM customers_cart WHERE customer_id = $1 AND product_id = $2;`
+	tag, err := s.pool.Exec(ctx, sql, id, productID)
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Checkout - buys the products of the cart by current prices in one transaction:
+// makes the sale of customer with promotions, decrements the stock and empties the cart.
+// The sale is made in the base currency unless other currency is requested.
+func (s *Service) Checkout(ctx context.Context, id int64, checkout *types.Checkout) (*types.Receipt, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	// cart rows are locked, so the same cart can't be checked out twice.
+	sql := `SELECT c.product_id, p.name, p.price, c.qty
+			FROM customers_cart c
+			JOIN products p ON p.id = c.product_id
+			WHERE c.customer_id = $1
+			ORDER BY c.created, c.product_id
+			FOR UPDATE OF c;`
+	rows, err := tx.Query(ctx, sql, id)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+	items, err := scanCart(rows)
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(items) == 0 {
+		return nil, &types.ValidationError{Field: "cart", Reason: "empty"}
+	}
+
+	sale := &types.Sale{CustomerID: id, PromoCode: checkout.PromoCode, Currency: checkout.Currency}
+	for _, item := range items {
+		sale.Positions = append(sale.Positions, &types.SalePosition{
+			ProductID: item.ProductID,
+			Qty:       item.Qty,
+		})
+	}
+
+	err = sales.Insert(ctx, tx, sale, s.pricing, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(ctx, `DELETE FROM customers_cart WHERE customer_id = $1;`, id)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+
+	receipt := &types.Receipt{
+		SaleID:     sale.ID,
+		CustomerID: id,
+		Discount:   types.NewMoney(0, sale.Currency),
+		Created:    sale.Created,
+	}
+	for i, position := range sale.Positions {
+		receipt.Positions = append(receipt.Positions, &types.ReceiptPosition{
+			ID:        position.ID,
+			ProductID: position.ProductID,
+			Name:      items[i].Name,
+			Price:     position.Price,
+			Qty:       position.Qty,
+			Discount:  position.Discount,
+			Tax:       position.Tax,
+			Sum:       position.Sum,
+		})
+		receipt.Discount = receipt.Discount.Add(position.Discount)
+	}
+	receipt.Tax = sale.Tax
+	receipt.Total = sale.Total
+	return receipt, nil
+}
+
+// scanCart - reads the items of the cart.
+func scanCart(rows pgx.Rows) ([]*types.CartItem, error) {
+	items := make([]*types.CartItem, 0)
+	for rows.Next() {
+		item := &types.CartItem{}
+		err := rows.Scan(&item.ProductID, &item.Name, &item.Price.Amount, &item.Qty)
+		if err != nil {
+			log.Println(err)
+			return nil, ErrInternal
+		}
+		item.Sum = item.Price.Mul(int64(item.Qty))
+		items = append(items, item)
+	}
+
+	err := rows.Err()
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+	return items, nil
+}
@@ -0,0 +1,175 @@
+package customers
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"log"
+	"math/big"
+
+	"github.com/jackc/pgx/v4"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/SardorMS/CRUD/pkg/types"
+)
+
+// resetCodeDigits - length of the code to reset the password.
+const resetCodeDigits = 6
+
+// RequestPasswordReset - sends the code to reset the password to customer phone,
+// unknown phones are silently ignored.
+func (s *Service) RequestPasswordReset(ctx context.Context, phone string) error {
+
+	var id int64
+	var requested int
+
+	sql1 := `SELECT c.id, 
+				(SELECT COUNT (*) FROM customers_reset_codes r 
+				 WHERE r.phone = c.phone AND r.created > CURRENT_TIMESTAMP - INTERVAL '1 hour')
+			FROM customers c WHERE c.phone = $1 AND c.deleted_at IS NULL;`
+	err := s.pool.QueryRow(ctx, sql1, phone).Scan(&id, &requested)
+	if err == pgx.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+
+	if requested >= s.reset.CodesPerHour {
+		return ErrTooManyRequests
+	}
+
+	max := big.NewInt(1)
+	for i := 0; i < resetCodeDigits; i++ {
+		max.Mul(max, big.NewInt(10))
+	}
+
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+	code := fmt.Sprintf("%0*d", resetCodeDigits, n)
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(code), bcrypt.DefaultCost)
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+
+	sql2 := `INSERT INTO customers_reset_codes (customer_id, phone, code, expire) 
+			VALUES ($1, $2, $3, CURRENT_TIMESTAMP + $4 * INTERVAL '1 second');`
+	_, err = s.pool.Exec(ctx, sql2, id, phone, hash, s.reset.TTL.Seconds())
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+
+	text := fmt.Sprintf("Your password reset code: %s", code)
+	if err = s.sms.Send(ctx, phone, text); err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+	return nil
+}
+
+// ResetPassword - sets a new password by the code and revokes all tokens of customer.
+func (s *Service) ResetPassword(ctx context.Context, reset *types.PasswordReset) error {
+
+	var id int64
+	var customerID int64
+	var hash string
+	var attempts int
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	sql := `SELECT id, customer_id, code, attempts FROM customers_reset_codes
+			WHERE phone = $1 AND NOT used AND expire > CURRENT_TIMESTAMP
+			ORDER BY created DESC
+			LIMIT 1
+			FOR UPDATE;`
+	err = tx.QueryRow(ctx, sql, reset.Phone).Scan(&id, &customerID, &hash, &attempts)
+	if err == pgx.ErrNoRows {
+		return ErrInvalidCode
+	}
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+
+	if attempts >= s.reset.Attempts {
+		return ErrTooManyRequests
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(reset.Code)) != nil {
+		sql = `UPDATE customers_reset_codes SET attempts = attempts + 1 WHERE id = $1;`
+		if _, err = tx.Exec(ctx, sql, id); err != nil {
+			log.Println(err)
+			return ErrInternal
+		}
+
+		if err = tx.Commit(ctx); err != nil {
+			log.Println(err)
+			return ErrInternal
+		}
+		return ErrInvalidCode
+	}
+
+	if err = s.passwords.Validate(reset.Password, reset.Phone); err != nil {
+		return err
+	}
+
+	newHash, err := bcrypt.GenerateFromPassword([]byte(reset.Password), bcrypt.DefaultCost)
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+
+	sql = `UPDATE customers SET password = $2 WHERE id = $1;`
+	if _, err = tx.Exec(ctx, sql, customerID, newHash); err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+
+	sql = `UPDATE customers_reset_codes SET used = TRUE WHERE customer_id = $1;`
+	if _, err = tx.Exec(ctx, sql, customerID); err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+
+	revoked := make([]string, 0)
+	sql = `DELETE FROM customers_tokens WHERE customer_id = $1 RETURNING token;`
+	rows, err := tx.Query(ctx, sql, customerID)
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var token string
+		if err = rows.Scan(&token); err != nil {
+			log.Println(err)
+			return ErrInternal
+		}
+		revoked = append(revoked, token)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+	return s.revokeSigned(ctx, revoked)
+}
@@ -6,11 +6,19 @@ import (
 	"encoding/hex"
 	"errors"
 	"log"
+	"time"
 
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"golang.org/x/crypto/bcrypt"
 
+	"github.com/SardorMS/CRUD/pkg/catalog"
+	"github.com/SardorMS/CRUD/pkg/config"
+	"github.com/SardorMS/CRUD/pkg/logins"
+	"github.com/SardorMS/CRUD/pkg/passwords"
+	"github.com/SardorMS/CRUD/pkg/sales"
+	"github.com/SardorMS/CRUD/pkg/sms"
+	"github.com/SardorMS/CRUD/pkg/tokens"
 	"github.com/SardorMS/CRUD/pkg/types"
 )
 
@@ -22,16 +30,45 @@ var (
 	ErrTokenNotFound   = errors.New("token not found")         //retrun when token not found.
 	ErrTokenExpired    = errors.New("token expired")           //return when token expired
 	ErrNotFound        = errors.New("not found")               // return not found
+	ErrInvalidCode     = errors.New("invalid code")            // return when reset code is wrong.
+	ErrTooManyRequests = errors.New("too many requests")       // return when attempts are exceeded.
 )
 
 //Service - describes customer service.
 type Service struct {
-	pool *pgxpool.Pool
+	pool      *pgxpool.Pool
+	sms       sms.Sender
+	logins    *logins.Service
+	signed    *tokens.Service
+	tokens    config.Tokens
+	passwords passwords.Policy
+	reset     resetSettings
+	pricing   sales.Pricing
+}
+
+// resetSettings - limits of the password reset.
+type resetSettings struct {
+	TTL          time.Duration
+	Attempts     int
+	CodesPerHour int
 }
 
 //newService - create a service.
-func NewService(pool *pgxpool.Pool) *Service {
-	return &Service{pool: pool}
+func NewService(pool *pgxpool.Pool, sender sms.Sender, loginsSvc *logins.Service, tokensSvc *tokens.Service, cfg *config.Config) *Service {
+	return &Service{
+		pool:      pool,
+		sms:       sender,
+		logins:    loginsSvc,
+		signed:    tokensSvc,
+		tokens:    cfg.Customers,
+		passwords: passwords.Policy{MinLength: cfg.MinPasswordLength},
+		reset: resetSettings{
+			TTL:          cfg.ResetCodeTTL,
+			Attempts:     cfg.ResetCodeAttempts,
+			CodesPerHour: cfg.ResetCodesPerHour,
+		},
+		pricing: sales.Pricing{Base: cfg.Currency, Currencies: cfg.Currencies},
+	}
 }
 
 // IDByToken - performs the customer authentication procedure,
@@ -39,54 +76,285 @@ func NewService(pool *pgxpool.Pool) *Service {
 func (s *Service) IDByToken(ctx context.Context, token string) (int64, error) {
 
 	var id int64
-	sql := `SELECT customer_id FROM customers_tokens WHERE token = $1;`
-	err := s.pool.QueryRow(ctx, sql, token).Scan(&id)
+	var expired bool
+	sql := `UPDATE customers_tokens SET last_used = CURRENT_TIMESTAMP WHERE token = $1 
+			RETURNING customer_id, expire <= CURRENT_TIMESTAMP;`
+	err := s.pool.QueryRow(ctx, sql, token).Scan(&id, &expired)
 
 	if err == pgx.ErrNoRows {
-		return 0, nil
+		return 0, ErrTokenNotFound
 	}
 
 	if err != nil {
+		log.Println(err)
 		return 0, ErrInternal
 	}
+
+	if expired {
+		return 0, ErrTokenExpired
+	}
 	return id, nil
 }
 
 //Tokern - generates a token for customer.
-func (s *Service) Token(ctx context.Context, phone string, password string) (token string, err error) {
+func (s *Service) Token(ctx context.Context, phone string, password string, client *types.Client) (*types.Token, error) {
 
 	var id int64
 	var hash string
 
-	sql1 := `SELECT id, password FROM customers WHERE phone = $1;`
-	err = s.pool.QueryRow(ctx, sql1, phone).Scan(&id, &hash)
+	err := s.logins.Check(ctx, logins.KindCustomer, phone, client.IP)
+	if err != nil {
+		return nil, err
+	}
+
+	sql := `SELECT id, password FROM customers WHERE phone = $1 AND deleted_at IS NULL;`
+	err = s.pool.QueryRow(ctx, sql, phone).Scan(&id, &hash)
 
 	if err == pgx.ErrNoRows {
-		return "", ErrNoSuchUser
+		if err = s.logins.Failed(ctx, logins.KindCustomer, phone, client); err != nil {
+			return nil, err
+		}
+		return nil, ErrNoSuchUser
 	}
 
 	if err != nil {
-		return "", ErrInternal
+		return nil, ErrInternal
 	}
 
 	err = bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 	if err != nil {
-		return "", ErrInvalidPassword
+		if err = s.logins.Failed(ctx, logins.KindCustomer, phone, client); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidPassword
 	}
 
-	buffer := make([]byte, 256)
-	n, err := rand.Read(buffer)
-	if n != len(buffer) || err != nil {
-		return "", ErrInternal
+	if err = s.logins.Succeeded(ctx, logins.KindCustomer, phone, id, client); err != nil {
+		return nil, err
+	}
+
+	return s.issueToken(ctx, id, client)
+}
+
+// RefreshToken - exchanges the refresh token for a new pair of tokens, the replaced token is revoked.
+func (s *Service) RefreshToken(ctx context.Context, refresh string, client *types.Client) (*types.Token, error) {
+
+	var id int64
+	var customerID int64
+	var expired bool
+	var replaced string
+	item := &types.Token{}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	sql := `SELECT id, customer_id, token, refresh_expire <= CURRENT_TIMESTAMP FROM customers_tokens 
+			WHERE refresh = $1 FOR UPDATE;`
+	err = tx.QueryRow(ctx, sql, refresh).Scan(&id, &customerID, &replaced, &expired)
+	if err == pgx.ErrNoRows {
+		return nil, ErrTokenNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+
+	if expired {
+		return nil, ErrTokenExpired
+	}
+
+	item.Token, err = s.accessToken(ctx, customerID)
+	if err != nil {
+		return nil, err
+	}
+
+	item.RefreshToken, err = generateToken()
+	if err != nil {
+		return nil, err
+	}
+
+	sql = `UPDATE customers_tokens 
+			SET token = $2, expire = CURRENT_TIMESTAMP + $3 * INTERVAL '1 second', 
+				refresh = $4, refresh_expire = CURRENT_TIMESTAMP + $5 * INTERVAL '1 second',
+				user_agent = $6, ip = $7, last_used = CURRENT_TIMESTAMP
+			WHERE id = $1
+			RETURNING expire, refresh_expire;`
+	err = tx.QueryRow(ctx, sql, id,
+		item.Token, s.tokens.TTL.Seconds(),
+		item.RefreshToken, s.tokens.RefreshTTL.Seconds(),
+		client.UserAgent, client.IP).Scan(&item.Expire, &item.RefreshExpire)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+
+	// replaced signed token stays valid by signature until it expires.
+	if err = s.revokeSigned(ctx, []string{replaced}); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// issueToken - saves a new pair of tokens for customer.
+func (s *Service) issueToken(ctx context.Context, id int64, client *types.Client) (*types.Token, error) {
+
+	var err error
+	item := &types.Token{}
+
+	item.Token, err = s.accessToken(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	item.RefreshToken, err = generateToken()
+	if err != nil {
+		return nil, err
+	}
+
+	sql := `INSERT INTO customers_tokens (token, customer_id, expire, refresh, refresh_expire, user_agent, ip) 
+			VALUES ($1, $2, CURRENT_TIMESTAMP + $3 * INTERVAL '1 second', 
+				$4, CURRENT_TIMESTAMP + $5 * INTERVAL '1 second', $6, $7)
+			RETURNING expire, refresh_expire;`
+	err = s.pool.QueryRow(ctx, sql, item.Token, id, s.tokens.TTL.Seconds(),
+		item.RefreshToken, s.tokens.RefreshTTL.Seconds(),
+		client.UserAgent, client.IP).Scan(&item.Expire, &item.RefreshExpire)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+	return item, nil
+}
+
+// RevokeToken - revokes the token (log out).
+func (s *Service) RevokeToken(ctx context.Context, token string) error {
+
+	sql := `DELETE FROM customers_tokens WHERE token = $1;`
+	tag, err := s.pool.Exec(ctx, sql, token)
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrTokenNotFound
+	}
+	return s.revokeSigned(ctx, []string{token})
+}
+
+// RevokeTokens - revokes all tokens of the customer (log out everywhere).
+func (s *Service) RevokeTokens(ctx context.Context, id int64) error {
+
+	revoked := make([]string, 0)
+	sql := `DELETE FROM customers_tokens WHERE customer_id = $1 RETURNING token;`
+	rows, err := s.pool.Query(ctx, sql, id)
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var token string
+		if err = rows.Scan(&token); err != nil {
+			log.Println(err)
+			return ErrInternal
+		}
+		revoked = append(revoked, token)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+
+	return s.revokeSigned(ctx, revoked)
+}
+
+// Sessions - shows active tokens of the customer, token is the current one.
+func (s *Service) Sessions(ctx context.Context, id int64, token string) ([]*types.Session, error) {
+
+	items := make([]*types.Session, 0)
+	sql := `SELECT id, user_agent, ip, token = $2, expire, refresh_expire, last_used, created 
+			FROM customers_tokens
+			WHERE customer_id = $1 AND refresh_expire > CURRENT_TIMESTAMP
+			ORDER BY last_used DESC;`
+	rows, err := s.pool.Query(ctx, sql, id, token)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := &types.Session{}
+		err = rows.Scan(
+			&item.ID,
+			&item.UserAgent,
+			&item.IP,
+			&item.Current,
+			&item.Expire,
+			&item.RefreshExpire,
+			&item.LastUsed,
+			&item.Created)
+
+		if err != nil {
+			log.Println(err)
+			return nil, ErrInternal
+		}
+		items = append(items, item)
 	}
 
-	token = hex.EncodeToString(buffer)
-	sql2 := `INSERT INTO customers_tokens(token, customer_id) VALUES($1, $2);`
-	_, err = s.pool.Exec(ctx, sql2, token, id)
+	err = rows.Err()
 	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+
+	return items, nil
+}
+
+// accessToken - generates access token for customer, signed one if signed tokens are enabled.
+func (s *Service) accessToken(ctx context.Context, id int64) (string, error) {
+	if !s.signed.Enabled() {
+		return generateToken()
+	}
+
+	token, _, err := s.signed.Issue(tokens.KindCustomer, id, nil, s.tokens.TTL)
+	return token, err
+}
+
+// revokeSigned - adds the signed tokens to deny-list, they stay valid until expiry otherwise.
+func (s *Service) revokeSigned(ctx context.Context, revoked []string) error {
+	if !s.signed.Enabled() {
+		return nil
+	}
+
+	for _, token := range revoked {
+		if err := s.signed.Revoke(ctx, token); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// generateToken - generates a random token.
+func generateToken() (string, error) {
+	buffer := make([]byte, 256)
+	n, err := rand.Read(buffer)
+	if n != len(buffer) || err != nil {
 		return "", ErrInternal
 	}
-	return token, nil
+	return hex.EncodeToString(buffer), nil
 }
 
 // Register - customers register procedure.
@@ -95,10 +363,14 @@ func (s *Service) Register(ctx context.Context, registration *types.Registration
 	var err error
 	item := &types.Customer{}
 
+	if err = s.passwords.Validate(registration.Password, registration.Phone); err != nil {
+		return nil, err
+	}
+
 	hash, err := bcrypt.GenerateFromPassword([]byte(registration.Password), bcrypt.DefaultCost)
 	if err != nil {
 		log.Println(err)
-		return nil, ErrPhoneUsed
+		return nil, ErrInternal
 	}
 
 	sql1 := `INSERT INTO customers (name, phone, password) 
@@ -113,7 +385,7 @@ func (s *Service) Register(ctx context.Context, registration *types.Registration
 
 	if err == pgx.ErrNoRows {
 		log.Println(err)
-		return nil, ErrNoSuchUser
+		return nil, ErrPhoneUsed
 	}
 	if err != nil {
 		log.Println(err)
@@ -122,29 +394,172 @@ func (s *Service) Register(ctx context.Context, registration *types.Registration
 	return item, nil
 }
 
-// Products - shows information about products to customers.
-func (s *Service) Products(ctx context.Context) ([]*types.Product, error) {
+// ChangePassword - changes the password of customer, the old one must match.
+func (s *Service) ChangePassword(ctx context.Context, id int64, change *types.PasswordChange) error {
+
+	var hash string
+	var phone string
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	sql1 := `SELECT phone, password FROM customers WHERE id = $1 FOR UPDATE;`
+	err = tx.QueryRow(ctx, sql1, id).Scan(&phone, &hash)
+	if err == pgx.ErrNoRows {
+		return ErrNoSuchUser
+	}
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(hash), []byte(change.OldPassword))
+	if err != nil {
+		return ErrInvalidPassword
+	}
+
+	if err = s.passwords.Validate(change.NewPassword, phone); err != nil {
+		return err
+	}
+
+	newHash, err := bcrypt.GenerateFromPassword([]byte(change.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+
+	sql2 := `UPDATE customers SET password = $2 WHERE id = $1;`
+	if _, err = tx.Exec(ctx, sql2, id, newHash); err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+	return nil
+}
+
+// productColumns - columns of products read into types.Product by scanProduct.
+const productColumns = `id, name, COALESCE (category_id, 0), COALESCE (sku, ''), COALESCE (barcode, ''), description, unit, price, qty`
+
+// Products - searches the products for customers, returns the page of them.
+func (s *Service) Products(ctx context.Context, filter *types.ProductFilter) ([]*types.Product, *types.Page, error) {
+
+	// customers see only active products.
+	active := true
+	filter.Active = &active
+	filter.Archived = false
+
+	query, err := catalog.NewQuery(productColumns, filter)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows, err := s.pool.Query(ctx, query.SQL, query.Args...)
+	if err != nil {
+		log.Println(err)
+		return nil, nil, ErrInternal
+	}
+	defer rows.Close()
 
 	items := make([]*types.Product, 0)
-	sql := `SELECT id, name, price, qty FROM products WHERE active ORDER BY id LIMIT 500;`
-	rows, err := s.pool.Query(ctx, sql)
+	keys := make([]string, 0)
+	for rows.Next() {
+		item := &types.Product{}
+		var key string
+		err = s.scanProduct(rows, item, &key)
+
+		if err != nil {
+			log.Println(err)
+			return nil, nil, ErrInternal
+		}
+		items = append(items, item)
+		keys = append(keys, key)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		log.Println(err)
+		return nil, nil, ErrInternal
+	}
+
+	page := &types.Page{}
+	if len(items) > query.Limit {
+		items = items[:query.Limit]
+		page.Next = query.Cursor(keys[query.Limit-1], items[query.Limit-1].ID)
+	}
+
+	err = s.pool.QueryRow(ctx, query.CountSQL, query.CountArgs...).Scan(&page.Total)
+	if err != nil {
+		log.Println(err)
+		return nil, nil, ErrInternal
+	}
+
+	return items, page, nil
+}
 
+// ProductByBarcode - finds the active product by barcode.
+func (s *Service) ProductByBarcode(ctx context.Context, barcode string) (*types.Product, error) {
+	item := &types.Product{}
+	sql := `SELECT ` + productColumns + ` FROM products WHERE barcode = $1 AND active AND deleted_at IS NULL;`
+	err := s.scanProduct(s.pool.QueryRow(ctx, sql, barcode), item)
 	if errors.Is(err, pgx.ErrNoRows) {
-		return items, nil
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
 	}
+	return item, nil
+}
 
+// scanProduct - reads the product selected by productColumns and the following columns,
+// the price is in the base currency.
+func (s *Service) scanProduct(row pgx.Row, item *types.Product, dest ...interface{}) error {
+	err := row.Scan(append([]interface{}{
+		&item.ID,
+		&item.Name,
+		&item.CategoryID,
+		&item.SKU,
+		&item.Barcode,
+		&item.Description,
+		&item.Unit,
+		&item.Price.Amount,
+		&item.Qty}, dest...)...)
+	item.Price.Currency = s.pricing.Base
+	return err
+}
+
+// Categories - shows the categories of products, parents go before their subcategories.
+func (s *Service) Categories(ctx context.Context) ([]*types.Category, error) {
+
+	items := make([]*types.Category, 0)
+	sql := `WITH RECURSIVE tree AS (
+				SELECT id, parent_id, name, created, ARRAY [name] AS path FROM categories WHERE parent_id IS NULL
+				UNION ALL
+				SELECT c.id, c.parent_id, c.name, c.created, t.path || c.name
+				FROM categories c JOIN tree t ON c.parent_id = t.id
+			)
+			SELECT id, COALESCE (parent_id, 0), name, created FROM tree ORDER BY path;`
+	rows, err := s.pool.Query(ctx, sql)
 	if err != nil {
+		log.Println(err)
 		return nil, ErrInternal
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		item := &types.Product{}
-		err = rows.Scan(&item.ID, &item.Name, &item.Price, &item.Qty)
-
+		item := &types.Category{}
+		err = rows.Scan(&item.ID, &item.ParentID, &item.Name, &item.Created)
 		if err != nil {
 			log.Println(err)
-			return nil, err
+			return nil, ErrInternal
 		}
 		items = append(items, item)
 	}
@@ -152,7 +567,7 @@ func (s *Service) Products(ctx context.Context) ([]*types.Product, error) {
 	err = rows.Err()
 	if err != nil {
 		log.Println(err)
-		return nil, err
+		return nil, ErrInternal
 	}
 
 	return items, nil
@@ -163,10 +578,12 @@ func (s *Service) Purchases(ctx context.Context, id int64) ([]*types.Sales, erro
 
 	items := make([]*types.Sales, 0)
 
-	sql := `SELECT sp.id, sp.name, sp.price, sp.qty, sp.created 
+	sql := `SELECT sp.id, p.name, sp.price, s.currency, sp.qty, sp.created
 			FROM sale_positions sp
 			JOIN sales s ON s.id = sp.sale_id
-			WHERE s.customer_id = $1;`
+			JOIN products p ON p.id = sp.product_id
+			WHERE s.customer_id = $1
+			ORDER BY sp.id;`
 	rows, err := s.pool.Query(ctx, sql, id)
 
 	if err != nil {
@@ -180,7 +597,8 @@ func (s *Service) Purchases(ctx context.Context, id int64) ([]*types.Sales, erro
 		err = rows.Scan(
 			&item.ID,
 			&item.Name,
-			&item.Price,
+			&item.Price.Amount,
+			&item.Price.Currency,
 			&item.Qty,
 			&item.Created)
 
@@ -199,21 +617,3 @@ func (s *Service) Purchases(ctx context.Context, id int64) ([]*types.Sales, erro
 
 	return items, nil
 }
-
-// MakePurchase - make a purchase.
-func (s *Service) MakePurchase(ctx context.Context, purchase *types.Sales) (*types.Sales, error) {
-
-	item := &types.Sales{}
-	sql := `INSERT INTO sale_positions (sale_id, qty, price) VALUES($1, $2, $3);`
-	err := s.pool.QueryRow(ctx, sql, purchase.ID, purchase.Qty, purchase.Price).Scan(
-		&purchase.ID,
-		&item.Qty,
-		&item.Price)
-
-	if err != nil {
-		log.Println(err)
-		return nil, ErrInternal
-	}
-	return item, nil
-
-}
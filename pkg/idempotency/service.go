@@ -0,0 +1,105 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+
+	"github.com/SardorMS/CRUD/pkg/config"
+)
+
+var (
+	ErrInternal   = errors.New("internal error")                           // return when an internal error occurred.
+	ErrMismatch   = errors.New("idempotency key used by other request")    // return when the body differs from the first request.
+	ErrInProgress = errors.New("request with idempotency key in progress") // return when the first request isn't finished.
+)
+
+// Response - stored response of the first request with the key.
+type Response struct {
+	Status      int
+	ContentType string
+	Body        []byte
+}
+
+// Service - describes idempotency keys service.
+type Service struct {
+	pool *pgxpool.Pool
+	ttl  time.Duration
+}
+
+// NewService - create a service.
+func NewService(pool *pgxpool.Pool, cfg *config.Config) *Service {
+	return &Service{pool: pool, ttl: cfg.IdempotencyKeyTTL}
+}
+
+// Begin - reserves the key for the request with the hash of body. Returns nil response
+// if the request must be processed, or the stored response of the first request.
+// Expired keys are reserved again.
+func (s *Service) Begin(ctx context.Context, scope string, key string, hash string) (*Response, error) {
+	sql := `DELETE FROM idempotency_keys WHERE scope = $1 AND key = $2 AND created < $3;`
+	_, err := s.pool.Exec(ctx, sql, scope, key, time.Now().Add(-s.ttl))
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+
+	sql = `INSERT INTO idempotency_keys (scope, key, hash) VALUES ($1, $2, $3)
+		   ON CONFLICT (scope, key) DO NOTHING RETURNING key;`
+	err = s.pool.QueryRow(ctx, sql, scope, key, hash).Scan(&key)
+	if err == nil {
+		return nil, nil
+	}
+	if err != pgx.ErrNoRows {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+
+	var stored string
+	var status *int
+	response := &Response{}
+	sql = `SELECT hash, status, content_type, body FROM idempotency_keys WHERE scope = $1 AND key = $2;`
+	err = s.pool.QueryRow(ctx, sql, scope, key).Scan(&stored, &status, &response.ContentType, &response.Body)
+	if err == pgx.ErrNoRows {
+		// the first request failed and released the key meanwhile.
+		return nil, ErrInProgress
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+
+	if stored != hash {
+		return nil, ErrMismatch
+	}
+	if status == nil {
+		return nil, ErrInProgress
+	}
+	response.Status = *status
+	return response, nil
+}
+
+// Finish - stores the response of the request with the key.
+func (s *Service) Finish(ctx context.Context, scope string, key string, response *Response) error {
+	sql := `UPDATE idempotency_keys SET status = $3, content_type = $4, body = $5 WHERE scope = $1 AND key = $2;`
+	_, err := s.pool.Exec(ctx, sql, scope, key, response.Status, response.ContentType, response.Body)
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+	return nil
+}
+
+// Release - removes the key of failed request, so it can be retried.
+func (s *Service) Release(ctx context.Context, scope string, key string) error {
+	sql := `DELETE FROM idempotency_keys WHERE scope = $1 AND key = $2 AND status IS NULL;`
+	_, err := s.pool.Exec(ctx, sql, scope, key)
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+package logins
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+
+	"github.com/SardorMS/CRUD/pkg/config"
+	"github.com/SardorMS/CRUD/pkg/types"
+)
+
+// Kinds of users which log in.
+const (
+	KindCustomer = "customer"
+	KindManager  = "manager"
+)
+
+// Scopes of failed logins counters.
+const (
+	scopePhone = "phone"
+	scopeIP    = "ip"
+)
+
+// maxDelay - the longest delay before the next login attempt.
+const maxDelay = time.Second * 10
+
+var ErrInternal = errors.New("internal error") //return when an internal error occurred.
+
+// LockedError - returned when logins are locked after too many failures.
+type LockedError struct {
+	Until time.Time
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("login locked until %s", e.Until.Format(time.RFC3339))
+}
+
+// Service - describes the login attempts service.
+type Service struct {
+	pool   *pgxpool.Pool
+	limits config.Logins
+}
+
+// NewService - create a service.
+func NewService(pool *pgxpool.Pool, cfg *config.Config) *Service {
+	return &Service{pool: pool, limits: cfg.Logins}
+}
+
+// Check - verifies that logins by phone and from ip aren't locked
+// and delays the attempt according to the number of recent failures.
+func (s *Service) Check(ctx context.Context, kind string, phone string, ip string) error {
+
+	var failures int
+	var lockedUntil *time.Time
+
+	sql := `SELECT COALESCE (MAX (failures) FILTER (WHERE scope = $5), 0), 
+				MAX (locked_until) FILTER (WHERE locked_until > CURRENT_TIMESTAMP)
+			FROM login_failures
+			WHERE kind = $1 AND ((scope = $5 AND key = $2) OR (scope = $6 AND key = $3))
+				AND (updated > CURRENT_TIMESTAMP - $4 * INTERVAL '1 second' OR locked_until > CURRENT_TIMESTAMP);`
+	err := s.pool.QueryRow(ctx, sql, kind, phone, ip, s.limits.Window.Seconds(),
+		scopePhone, scopeIP).Scan(&failures, &lockedUntil)
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+
+	if lockedUntil != nil {
+		return &LockedError{Until: *lockedUntil}
+	}
+
+	if failures == 0 {
+		return nil
+	}
+
+	delay := s.limits.Delay
+	for i := 1; i < failures && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Failed - records the failed login, locks logins by phone and from ip
+// when there are too many failures.
+func (s *Service) Failed(ctx context.Context, kind string, phone string, client *types.Client) error {
+
+	err := s.record(ctx, kind, phone, 0, false, client)
+	if err != nil {
+		return err
+	}
+
+	sql := `INSERT INTO login_failures (kind, scope, key, failures) VALUES ($1, $2, $3, 1)
+			ON CONFLICT (kind, scope, key) DO UPDATE SET 
+				failures = CASE WHEN login_failures.updated > CURRENT_TIMESTAMP - $4 * INTERVAL '1 second'
+					THEN login_failures.failures + 1 ELSE 1 END,
+				updated = CURRENT_TIMESTAMP
+			RETURNING failures;`
+	update := `UPDATE login_failures SET locked_until = CURRENT_TIMESTAMP + $4 * INTERVAL '1 second'
+			WHERE kind = $1 AND scope = $2 AND key = $3;`
+
+	counters := []struct {
+		scope string
+		key   string
+		limit int
+	}{
+		{scopePhone, phone, s.limits.MaxFailures},
+		{scopeIP, client.IP, s.limits.MaxFailuresPerIP},
+	}
+
+	for _, counter := range counters {
+		var failures int
+		err = s.pool.QueryRow(ctx, sql, kind, counter.scope, counter.key, s.limits.Window.Seconds()).Scan(&failures)
+		if err != nil {
+			log.Println(err)
+			return ErrInternal
+		}
+
+		if failures < counter.limit {
+			continue
+		}
+
+		_, err = s.pool.Exec(ctx, update, kind, counter.scope, counter.key, s.limits.Lockout.Seconds())
+		if err != nil {
+			log.Println(err)
+			return ErrInternal
+		}
+	}
+	return nil
+}
+
+// Succeeded - records the successful login and resets failures of the phone.
+func (s *Service) Succeeded(ctx context.Context, kind string, phone string, id int64, client *types.Client) error {
+
+	err := s.record(ctx, kind, phone, id, true, client)
+	if err != nil {
+		return err
+	}
+
+	return s.Unlock(ctx, kind, phone)
+}
+
+// Unlock - removes the lock and failures of the phone.
+func (s *Service) Unlock(ctx context.Context, kind string, phone string) error {
+
+	sql := `DELETE FROM login_failures WHERE kind = $1 AND scope = $2 AND key = $3;`
+	_, err := s.pool.Exec(ctx, sql, kind, scopePhone, phone)
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+	return nil
+}
+
+// History - shows the recorded logins, newest first.
+func (s *Service) History(ctx context.Context, filter *types.LoginFilter) ([]*types.Login, error) {
+
+	items := make([]*types.Login, 0)
+
+	conditions := make([]string, 0)
+	args := make([]interface{}, 0)
+	if filter.Kind != "" {
+		args = append(args, filter.Kind)
+		conditions = append(conditions, fmt.Sprintf("kind = $%d", len(args)))
+	}
+	if filter.Phone != "" {
+		args = append(args, filter.Phone)
+		conditions = append(conditions, fmt.Sprintf("phone = $%d", len(args)))
+	}
+	if filter.IP != "" {
+		args = append(args, filter.IP)
+		conditions = append(conditions, fmt.Sprintf("ip = $%d", len(args)))
+	}
+	if filter.Success != nil {
+		args = append(args, *filter.Success)
+		conditions = append(conditions, fmt.Sprintf("success = $%d", len(args)))
+	}
+
+	where := ""
+	if len(conditions) != 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	args = append(args, filter.Limit)
+	sql := `SELECT id, kind, phone, COALESCE (user_id, 0), success, ip, user_agent, created 
+			FROM login_history ` + where + fmt.Sprintf(` ORDER BY id DESC LIMIT $%d;`, len(args))
+
+	rows, err := s.pool.Query(ctx, sql, args...)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := &types.Login{}
+		err = rows.Scan(
+			&item.ID,
+			&item.Kind,
+			&item.Phone,
+			&item.UserID,
+			&item.Success,
+			&item.IP,
+			&item.UserAgent,
+			&item.Created)
+
+		if err != nil {
+			log.Println(err)
+			return nil, ErrInternal
+		}
+		items = append(items, item)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+
+	return items, nil
+}
+
+// record - saves the login attempt to history.
+func (s *Service) record(ctx context.Context, kind string, phone string, id int64, success bool, client *types.Client) error {
+
+	sql := `INSERT INTO login_history (kind, phone, user_id, success, ip, user_agent) 
+			VALUES ($1, $2, NULLIF ($3, 0), $4, $5, $6);`
+	_, err := s.pool.Exec(ctx, sql, kind, phone, id, success, client.IP, client.UserAgent)
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+	return nil
+}
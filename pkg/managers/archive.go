@@ -0,0 +1,146 @@
+package managers
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+
+	"github.com/SardorMS/CRUD/pkg/types"
+)
+
+// RestoreProduct - returns the archived product with the version to the catalog, it's active
+// if it was active before archiving.
+func (s *Service) RestoreProduct(ctx context.Context, id int64, version int64) (*types.Products, error) {
+	item := &types.Products{}
+
+	sql := `UPDATE products SET deleted_at = NULL, version = version + 1
+			WHERE id = $1 AND deleted_at IS NOT NULL AND ($2 = 0 OR version = $2)
+			RETURNING ` + productColumns + `;`
+	err := scanProduct(s.pool.QueryRow(ctx, sql, id, version), item)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, unchangedError(ctx, s.pool, "products", "deleted_at IS NOT NULL", id, version)
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+	item.Price.Currency = s.pricing.Base
+	return item, nil
+}
+
+// PurgeProduct - deletes the archived product with the version, which isn't sold, in carts or promotions.
+func (s *Service) PurgeProduct(ctx context.Context, id int64, version int64) error {
+	sql := `DELETE FROM products WHERE id = $1 AND deleted_at IS NOT NULL AND ($2 = 0 OR version = $2);`
+	tag, err := s.pool.Exec(ctx, sql, id, version)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == foreignKeyViolation {
+		return ErrReferenced
+	}
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+	if tag.RowsAffected() == 0 {
+		return unchangedError(ctx, s.pool, "products", "deleted_at IS NOT NULL", id, version)
+	}
+	return nil
+}
+
+// RestoreCustomer - returns the archived customer with the version, the customer can sign in again,
+// it's active if it was active before archiving.
+func (s *Service) RestoreCustomer(ctx context.Context, id int64, version int64) (*types.Customers, error) {
+	item := &types.Customers{}
+
+	sql := `UPDATE customers SET deleted_at = NULL, version = version + 1
+			WHERE id = $1 AND deleted_at IS NOT NULL AND ($2 = 0 OR version = $2)
+			RETURNING id, name, phone, active, version, deleted_at, created;`
+	err := s.pool.QueryRow(ctx, sql, id, version).Scan(
+		&item.ID,
+		&item.Name,
+		&item.Phone,
+		&item.Active,
+		&item.Version,
+		&item.DeletedAt,
+		&item.Created)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, unchangedError(ctx, s.pool, "customers", "deleted_at IS NOT NULL", id, version)
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+	return item, nil
+}
+
+// PurgeCustomer - deletes the archived customer with the version, who has no purchases,
+// with the sessions, reset codes and the cart.
+func (s *Service) PurgeCustomer(ctx context.Context, id int64, version int64) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	var archived bool
+	var current int64
+	sql := `SELECT deleted_at IS NOT NULL, version FROM customers WHERE id = $1 FOR UPDATE;`
+	err = tx.QueryRow(ctx, sql, id).Scan(&archived, &current)
+	if errors.Is(err, pgx.ErrNoRows) || err == nil && !archived {
+		return ErrNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+
+	err = checkVersion(current, version)
+	if err != nil {
+		return err
+	}
+
+	// sales don't reference customers by foreign key, so purchases are checked here.
+	var purchased bool
+	sql = `SELECT EXISTS (SELECT 1 FROM sales WHERE customer_id = $1);`
+	err = tx.QueryRow(ctx, sql, id).Scan(&purchased)
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+	if purchased {
+		return ErrReferenced
+	}
+
+	revoked, err := deleteCustomerTokens(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	sqls := []string{
+		`DELETE FROM customers_reset_codes WHERE customer_id = $1;`,
+		`DELETE FROM customers_cart WHERE customer_id = $1;`,
+		`DELETE FROM customers WHERE id = $1;`,
+	}
+	for _, sql := range sqls {
+		_, err = tx.Exec(ctx, sql, id)
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == foreignKeyViolation {
+			return ErrReferenced
+		}
+		if err != nil {
+			log.Println(err)
+			return ErrInternal
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+	return s.revokeSigned(ctx, revoked)
+}
@@ -0,0 +1,74 @@
+package managers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+
+	"github.com/SardorMS/CRUD/pkg/types"
+)
+
+// Categories - shows the categories of products, parents go before their subcategories.
+func (s *Service) Categories(ctx context.Context) ([]*types.Category, error) {
+
+	items := make([]*types.Category, 0)
+	sql := `WITH RECURSIVE tree AS (
+				SELECT id, parent_id, name, created, ARRAY [name] AS path FROM categories WHERE parent_id IS NULL
+				UNION ALL
+				SELECT c.id, c.parent_id, c.name, c.created, t.path || c.name
+				FROM categories c JOIN tree t ON c.parent_id = t.id
+			)
+			SELECT id, COALESCE (parent_id, 0), name, created FROM tree ORDER BY path;`
+	rows, err := s.pool.Query(ctx, sql)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := &types.Category{}
+		err = rows.Scan(&item.ID, &item.ParentID, &item.Name, &item.Created)
+		if err != nil {
+			log.Println(err)
+			return nil, ErrInternal
+		}
+		items = append(items, item)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+
+	return items, nil
+}
+
+// CreateCategory - creates the category of products with the name unique within the parent.
+func (s *Service) CreateCategory(ctx context.Context, item *types.Category) (*types.Category, error) {
+	item.Name = strings.TrimSpace(item.Name)
+	if item.Name == "" {
+		return nil, &types.ValidationError{Field: "name", Reason: "empty"}
+	}
+
+	sql := `INSERT INTO categories (parent_id, name) VALUES (NULLIF ($1::BIGINT, 0), $2)
+			ON CONFLICT ((COALESCE (parent_id, 0)), name) DO NOTHING RETURNING id, created;`
+	err := s.pool.QueryRow(ctx, sql, item.ParentID, item.Name).Scan(&item.ID, &item.Created)
+	if err == pgx.ErrNoRows {
+		return nil, ErrCategoryExists
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == foreignKeyViolation {
+		return nil, &types.ValidationError{Field: "parent_id", Reason: "not_found"}
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+	return item, nil
+}
@@ -0,0 +1,69 @@
+package managers
+
+import (
+	"context"
+	"log"
+	"math/big"
+	"strings"
+
+	"github.com/SardorMS/CRUD/pkg/types"
+)
+
+// ExchangeRates - shows the exchange rates of the currencies to the base currency.
+func (s *Service) ExchangeRates(ctx context.Context) ([]*types.ExchangeRate, error) {
+
+	items := make([]*types.ExchangeRate, 0)
+	sql := `SELECT currency, rate::TEXT, updated FROM exchange_rates ORDER BY currency;`
+	rows, err := s.pool.Query(ctx, sql)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := &types.ExchangeRate{}
+		err = rows.Scan(&item.Currency, &item.Rate, &item.Updated)
+		if err != nil {
+			log.Println(err)
+			return nil, ErrInternal
+		}
+		items = append(items, item)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+
+	return items, nil
+}
+
+// SetExchangeRate - sets the exchange rate of the supported currency, made sales
+// keep the rate they were made with.
+func (s *Service) SetExchangeRate(ctx context.Context, item *types.ExchangeRate) (*types.ExchangeRate, error) {
+	item.Currency = strings.ToUpper(item.Currency)
+	supported := false
+	for _, currency := range s.pricing.Currencies {
+		supported = supported || currency == item.Currency
+	}
+	if !supported || item.Currency == s.pricing.Base {
+		return nil, &types.ValidationError{Field: "currency", Reason: "not_supported"}
+	}
+
+	rate, ok := new(big.Rat).SetString(strings.TrimSpace(item.Rate))
+	if !ok || rate.Sign() <= 0 {
+		return nil, &types.ValidationError{Field: "rate", Reason: "not_positive"}
+	}
+
+	sql := `INSERT INTO exchange_rates (currency, rate) VALUES ($1, $2::NUMERIC)
+			ON CONFLICT (currency) DO UPDATE SET rate = excluded.rate, updated = CURRENT_TIMESTAMP
+			RETURNING rate::TEXT, updated;`
+	err := s.pool.QueryRow(ctx, sql, item.Currency, rate.FloatString(12)).Scan(&item.Rate, &item.Updated)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+	return item, nil
+}
@@ -0,0 +1,150 @@
+package managers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+
+	"github.com/jackc/pgx/v4"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/SardorMS/CRUD/pkg/types"
+)
+
+// SetupPassword - sets up the password of manager by one-time code,
+// if successfull returns a token.
+func (s *Service) SetupPassword(ctx context.Context, setup *types.PasswordSetup, client *types.Client) (*types.Token, error) {
+
+	var id int64
+	var phone string
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	sql1 := `SELECT m.id, m.phone FROM managers_setup_codes c
+			JOIN managers m ON m.id = c.manager_id
+			WHERE c.code = $1 AND NOT c.used AND c.expire > CURRENT_TIMESTAMP
+			FOR UPDATE OF c;`
+	err = tx.QueryRow(ctx, sql1, hashCode(setup.Code)).Scan(&id, &phone)
+	if err == pgx.ErrNoRows {
+		return nil, ErrInvalidCode
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+
+	if err = s.passwords.Validate(setup.Password, phone); err != nil {
+		return nil, err
+	}
+
+	if err = setPassword(ctx, tx, id, setup.Password); err != nil {
+		return nil, err
+	}
+
+	sql2 := `UPDATE managers_setup_codes SET used = TRUE WHERE manager_id = $1;`
+	if _, err = tx.Exec(ctx, sql2, id); err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+
+	return s.issueToken(ctx, id, client)
+}
+
+// ChangePassword - changes the password of manager, the old one must match.
+func (s *Service) ChangePassword(ctx context.Context, id int64, change *types.PasswordChange) error {
+
+	var hash *string
+	var phone string
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	sql := `SELECT phone, password FROM managers WHERE id = $1 FOR UPDATE;`
+	err = tx.QueryRow(ctx, sql, id).Scan(&phone, &hash)
+	if err == pgx.ErrNoRows {
+		return ErrNoSuchUser
+	}
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+
+	if hash == nil || bcrypt.CompareHashAndPassword([]byte(*hash), []byte(change.OldPassword)) != nil {
+		return ErrInvalidPassword
+	}
+
+	if err = s.passwords.Validate(change.NewPassword, phone); err != nil {
+		return err
+	}
+
+	if err = setPassword(ctx, tx, id, change.NewPassword); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+	return nil
+}
+
+// insertSetupCode - generates one-time code to set up the password of manager.
+func (s *Service) insertSetupCode(ctx context.Context, tx pgx.Tx, id int64) (*types.SetupCode, error) {
+
+	buffer := make([]byte, 16)
+	n, err := rand.Read(buffer)
+	if n != len(buffer) || err != nil {
+		return nil, ErrInternal
+	}
+
+	item := &types.SetupCode{ManagerID: id, Code: hex.EncodeToString(buffer)}
+
+	sql := `INSERT INTO managers_setup_codes (code, manager_id, expire) 
+			VALUES ($1, $2, CURRENT_TIMESTAMP + $3 * INTERVAL '1 second')
+			RETURNING expire;`
+	err = tx.QueryRow(ctx, sql, hashCode(item.Code), id, s.setupCodeTTL.Seconds()).Scan(&item.Expire)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+	return item, nil
+}
+
+// setPassword - saves bcrypt hash of the password.
+func setPassword(ctx context.Context, tx pgx.Tx, id int64, password string) error {
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+
+	sql := `UPDATE managers SET password = $2 WHERE id = $1;`
+	if _, err = tx.Exec(ctx, sql, id, hash); err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+	return nil
+}
+
+// hashCode - setup codes are stored as hashes.
+func hashCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
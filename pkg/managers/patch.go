@@ -0,0 +1,37 @@
+package managers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+
+	"github.com/SardorMS/CRUD/pkg/patch"
+	"github.com/SardorMS/CRUD/pkg/types"
+)
+
+// ErrInvalidPatch - return when changes aren't JSON Merge Patch.
+var ErrInvalidPatch = patch.ErrInvalidPatch
+
+// applyPatch - applies JSON Merge Patch to the saved item and reads the result into dest.
+func applyPatch(item interface{}, changes []byte, dest interface{}) error {
+	document, err := json.Marshal(item)
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+
+	document, err = patch.Merge(document, changes)
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal(document, dest)
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return &types.ValidationError{Field: typeErr.Field, Reason: "invalid"}
+	}
+	if err != nil {
+		return ErrInvalidPatch
+	}
+	return nil
+}
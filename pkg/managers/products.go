@@ -0,0 +1,119 @@
+package managers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+
+	"github.com/SardorMS/CRUD/pkg/types"
+)
+
+// productColumns - columns of products read into types.Products by scanProduct.
+const productColumns = `id, name, COALESCE (category_id, 0), COALESCE (tax_class_id, 0), COALESCE (sku, ''), COALESCE (barcode, ''),
+						description, unit, price, qty, active, version, deleted_at, created`
+
+// Units of measure of products.
+var units = map[string]bool{
+	"pcs": true,
+	"kg":  true,
+	"g":   true,
+	"l":   true,
+	"ml":  true,
+	"m":   true,
+	"box": true,
+}
+
+// scanProduct - reads the product selected by productColumns and the following columns.
+func scanProduct(row pgx.Row, item *types.Products, dest ...interface{}) error {
+	return row.Scan(append([]interface{}{
+		&item.ID,
+		&item.Name,
+		&item.CategoryID,
+		&item.TaxClassID,
+		&item.SKU,
+		&item.Barcode,
+		&item.Description,
+		&item.Unit,
+		&item.Price.Amount,
+		&item.Qty,
+		&item.Active,
+		&item.Version,
+		&item.DeletedAt,
+		&item.Created}, dest...)...)
+}
+
+// ProductByBarcode - finds the active product, which isn't archived, by scanned barcode.
+func (s *Service) ProductByBarcode(ctx context.Context, barcode string) (*types.Products, error) {
+	item := &types.Products{}
+	sql := `SELECT ` + productColumns + ` FROM products WHERE barcode = $1 AND active AND deleted_at IS NULL;`
+	err := scanProduct(s.pool.QueryRow(ctx, sql, barcode), item)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+	item.Price.Currency = s.pricing.Base
+
+	err = s.productPrices(ctx, []*types.Products{item})
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// validateCatalog - checks the name, SKU, barcode and unit of product, pcs is the default unit.
+func validateCatalog(product *types.Products) error {
+	product.Name = strings.TrimSpace(product.Name)
+	if product.Name == "" {
+		return &types.ValidationError{Field: "name", Reason: "empty"}
+	}
+
+	product.SKU = strings.TrimSpace(product.SKU)
+	if len(product.SKU) > 64 {
+		return &types.ValidationError{Field: "sku", Reason: "too_long"}
+	}
+
+	product.Barcode = strings.TrimSpace(product.Barcode)
+	if product.Barcode != "" && !validBarcode(product.Barcode) {
+		return &types.ValidationError{Field: "barcode", Reason: "invalid"}
+	}
+
+	product.Description = strings.TrimSpace(product.Description)
+
+	product.Unit = strings.ToLower(strings.TrimSpace(product.Unit))
+	if product.Unit == "" {
+		product.Unit = "pcs"
+	}
+	if !units[product.Unit] {
+		return &types.ValidationError{Field: "unit", Reason: "unknown"}
+	}
+	return nil
+}
+
+// validBarcode - checks that the barcode is EAN-8, UPC-A, EAN-13 or GTIN-14 with valid check digit.
+func validBarcode(barcode string) bool {
+	switch len(barcode) {
+	case 8, 12, 13, 14:
+	default:
+		return false
+	}
+
+	// digits are weighted 3 and 1 from the right, the check digit completes the sum to tens.
+	sum := 0
+	for i := len(barcode) - 1; i >= 0; i-- {
+		digit := int(barcode[i] - '0')
+		if digit < 0 || digit > 9 {
+			return false
+		}
+		if (len(barcode)-1-i)%2 == 1 {
+			digit *= 3
+		}
+		sum += digit
+	}
+	return sum%10 == 0
+}
@@ -0,0 +1,37 @@
+package managers
+
+import "testing"
+
+func TestValidBarcode(t *testing.T) {
+	tests := []struct {
+		name    string
+		barcode string
+		want    bool
+	}{
+		{"EAN-13", "4006381333931", true},
+		{"EAN-8", "96385074", true},
+		{"UPC-A", "036000291452", true},
+		{"GTIN-14", "10012345678902", true},
+		{"zeros", "00000000", true},
+		{"EAN-13 bad check digit", "4006381333932", false},
+		{"EAN-8 bad check digit", "96385070", false},
+		{"UPC-A bad check digit", "036000291453", false},
+		{"GTIN-14 bad check digit", "10012345678901", false},
+		{"swapped digits", "4003681333931", false},
+		{"letter", "40063813339a1", false},
+		{"space", "4006381 33931", false},
+		{"sign", "+006381333931", false},
+		{"too short", "9638507", false},
+		{"bad length", "40063813339", false},
+		{"too long", "400638133393100", false},
+		{"empty", "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := validBarcode(test.barcode); got != test.want {
+				t.Errorf("validBarcode(%q) = %v, want %v", test.barcode, got, test.want)
+			}
+		})
+	}
+}
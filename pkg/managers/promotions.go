@@ -0,0 +1,187 @@
+package managers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+
+	"github.com/SardorMS/CRUD/pkg/sales"
+	"github.com/SardorMS/CRUD/pkg/types"
+)
+
+// uniqueViolation - postgres error code of violated unique constraint.
+const uniqueViolation = "23505"
+
+const promotionColumns = `id, name, kind, value, buy, get, COALESCE (product_id, 0), COALESCE (category_id, 0),
+	COALESCE (code, ''), COALESCE (usage_limit, 0), used, starts, ends, active, created`
+
+// Promotions - shows all promotions, the latest first.
+func (s *Service) Promotions(ctx context.Context) ([]*types.Promotion, error) {
+
+	items := make([]*types.Promotion, 0)
+	sql := `SELECT ` + promotionColumns + ` FROM promotions ORDER BY id DESC;`
+	rows, err := s.pool.Query(ctx, sql)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item, err := scanPromotion(rows)
+		if err != nil {
+			log.Println(err)
+			return nil, ErrInternal
+		}
+		items = append(items, item)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+
+	return items, nil
+}
+
+// CreatePromotion - creates the promotion, it's active from creation.
+func (s *Service) CreatePromotion(ctx context.Context, item *types.Promotion) (*types.Promotion, error) {
+	if err := validatePromotion(item); err != nil {
+		return nil, err
+	}
+
+	sql := `INSERT INTO promotions (name, kind, value, buy, get, product_id, category_id, code, usage_limit, starts, ends)
+			VALUES ($1, $2, $3, $4, $5, NULLIF ($6::BIGINT, 0), NULLIF ($7::BIGINT, 0), NULLIF ($8, ''),
+					NULLIF ($9::INTEGER, 0), $10, $11)
+			RETURNING ` + promotionColumns + `;`
+	row := s.pool.QueryRow(ctx, sql, item.Name, item.Kind, item.Value, item.Buy, item.Get, item.ProductID,
+		item.CategoryID, item.Code, item.UsageLimit, item.Starts, item.Ends)
+
+	return promotionResult(scanPromotion(row))
+}
+
+// ChangePromotion - changes the promotion, counter of used promo codes is kept.
+func (s *Service) ChangePromotion(ctx context.Context, item *types.Promotion) (*types.Promotion, error) {
+	if err := validatePromotion(item); err != nil {
+		return nil, err
+	}
+
+	sql := `UPDATE promotions SET name = $2, kind = $3, value = $4, buy = $5, get = $6,
+				   product_id = NULLIF ($7::BIGINT, 0), category_id = NULLIF ($8::BIGINT, 0), code = NULLIF ($9, ''),
+				   usage_limit = NULLIF ($10::INTEGER, 0), starts = $11, ends = $12, active = $13
+			WHERE id = $1
+			RETURNING ` + promotionColumns + `;`
+	row := s.pool.QueryRow(ctx, sql, item.ID, item.Name, item.Kind, item.Value, item.Buy, item.Get, item.ProductID,
+		item.CategoryID, item.Code, item.UsageLimit, item.Starts, item.Ends, item.Active)
+
+	return promotionResult(scanPromotion(row))
+}
+
+// RemovePromotion - deactivates the promotion, it's kept for positions which got its discount.
+func (s *Service) RemovePromotion(ctx context.Context, id int64) error {
+	tag, err := s.pool.Exec(ctx, `UPDATE promotions SET active = FALSE WHERE id = $1;`, id)
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// validatePromotion - checks the rules of promotion of each kind.
+func validatePromotion(item *types.Promotion) error {
+	item.Name = strings.TrimSpace(item.Name)
+	item.Code = strings.TrimSpace(item.Code)
+
+	if item.Name == "" {
+		return &types.ValidationError{Field: "name", Reason: "empty"}
+	}
+
+	switch item.Kind {
+	case sales.KindPercent:
+		if item.Value <= 0 || item.Value > 100 {
+			return &types.ValidationError{Field: "value", Reason: "out_of_range"}
+		}
+	case sales.KindFixed:
+		if item.Value <= 0 {
+			return &types.ValidationError{Field: "value", Reason: "not_positive"}
+		}
+	case sales.KindBuyGet:
+		if item.Buy <= 0 {
+			return &types.ValidationError{Field: "buy", Reason: "not_positive"}
+		}
+		if item.Get <= 0 {
+			return &types.ValidationError{Field: "get", Reason: "not_positive"}
+		}
+	default:
+		return &types.ValidationError{Field: "kind", Reason: "unknown"}
+	}
+
+	if item.ProductID != 0 && item.CategoryID != 0 {
+		return &types.ValidationError{Field: "category_id", Reason: "product_and_category"}
+	}
+	if item.UsageLimit < 0 {
+		return &types.ValidationError{Field: "usage_limit", Reason: "negative"}
+	}
+	if item.UsageLimit != 0 && item.Code == "" {
+		return &types.ValidationError{Field: "usage_limit", Reason: "without_code"}
+	}
+	if item.Starts != nil && item.Ends != nil && !item.Ends.After(*item.Starts) {
+		return &types.ValidationError{Field: "ends", Reason: "before_starts"}
+	}
+	return nil
+}
+
+// scanPromotion - reads the promotion from the row.
+func scanPromotion(row pgx.Row) (*types.Promotion, error) {
+	item := &types.Promotion{}
+	err := row.Scan(
+		&item.ID,
+		&item.Name,
+		&item.Kind,
+		&item.Value,
+		&item.Buy,
+		&item.Get,
+		&item.ProductID,
+		&item.CategoryID,
+		&item.Code,
+		&item.UsageLimit,
+		&item.Used,
+		&item.Starts,
+		&item.Ends,
+		&item.Active,
+		&item.Created)
+	return item, err
+}
+
+// promotionResult - maps the errors of promotion saving.
+func promotionResult(item *types.Promotion, err error) (*types.Promotion, error) {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+		return nil, ErrPromoCodeUsed
+	}
+	if errors.As(err, &pgErr) && pgErr.Code == foreignKeyViolation {
+		field := "product_id"
+		if strings.Contains(pgErr.ConstraintName, "category") {
+			field = "category_id"
+		}
+		return nil, &types.ValidationError{Field: field, Reason: "not_found"}
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+	return item, nil
+}
@@ -0,0 +1,227 @@
+package managers
+
+import (
+	"context"
+	"log"
+
+	"github.com/jackc/pgx/v4"
+
+	"github.com/SardorMS/CRUD/pkg/sales"
+	"github.com/SardorMS/CRUD/pkg/types"
+)
+
+// soldPosition - position of the sale with quantity which can still be returned.
+type soldPosition struct {
+	productID    int64
+	price        int64
+	qty          int
+	discount     int64
+	tax          int64
+	taxInclusive bool
+	returned     int
+}
+
+// refund - amount paid for qty units of the position returned after the already returned ones
+// and its tax, the discount and the tax of the position are shared by its units so the full
+// return refunds exactly the paid sum and tax.
+func (p *soldPosition) refund(qty int) (amount int64, tax int64) {
+	paid := p.price*int64(p.qty) - p.discount
+	if !p.taxInclusive {
+		paid += p.tax
+	}
+	return share(paid, p.returned, qty, p.qty), share(p.tax, p.returned, qty, p.qty)
+}
+
+// share - part of the sum for qty units after the already returned ones, so the parts
+// of all units add up to the sum.
+func share(sum int64, returned int, qty int, total int) int64 {
+	return sum*int64(returned+qty)/int64(total) - sum*int64(returned)/int64(total)
+}
+
+// MakeReturn - returns the products of paid sale in one transaction: checks that the quantity
+// was sold and not returned yet, puts the products back in stock, computes the refund
+// by prices, discounts and taxes of the sale and gives it back by the methods of payments. If ownerID isn't 0 only the sale made by this manager is found.
+func (s *Service) MakeReturn(ctx context.Context, item *types.SaleReturn, ownerID int64) (*types.SaleReturn, error) {
+	if len(item.Positions) == 0 {
+		return nil, &types.ValidationError{Field: "positions", Reason: "empty"}
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	// the sale is locked, so concurrent returns of it are made one by one.
+	sale, err := lockSale(ctx, tx, item.SaleID, ownerID)
+	if err != nil {
+		return nil, err
+	}
+
+	if sale.Status != sales.StatusPaid && sale.Status != sales.StatusPartiallyRefunded {
+		return nil, sales.ErrInvalidStatus
+	}
+
+	sold, err := soldPositions(ctx, tx, item.SaleID)
+	if err != nil {
+		return nil, err
+	}
+
+	item.Amount = types.NewMoney(0, sale.Currency)
+	for _, position := range item.Positions {
+		if position.Qty <= 0 {
+			return nil, &types.ValidationError{Field: "qty", Reason: "not_positive"}
+		}
+
+		origin, ok := sold[position.PositionID]
+		if !ok {
+			return nil, &types.ValidationError{Field: "position_id", Reason: "not_found"}
+		}
+		if origin.qty-origin.returned < position.Qty {
+			return nil, &types.ValidationError{Field: "qty", Reason: "exceeds_sold"}
+		}
+
+		position.ProductID = origin.productID
+		amount, tax := origin.refund(position.Qty)
+		position.Price = types.NewMoney(origin.price, sale.Currency)
+		position.Amount = types.NewMoney(amount, sale.Currency)
+		position.Tax = types.NewMoney(tax, sale.Currency)
+		origin.returned += position.Qty
+		item.Amount = item.Amount.Add(position.Amount)
+	}
+
+	sql := `INSERT INTO sale_returns (sale_id, manager_id, amount, reason) VALUES ($1, $2, $3, $4)
+		   RETURNING id, created;`
+	err = tx.QueryRow(ctx, sql, item.SaleID, item.ManagerID, item.Amount.Amount, item.Reason).Scan(&item.ID, &item.Created)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+
+	err = insertReturnPositions(ctx, tx, item)
+	if err != nil {
+		return nil, err
+	}
+
+	item.Refunds, err = sales.Refund(ctx, tx, sale, item.Amount.Amount, item.ID, item.ManagerID)
+	if err != nil {
+		return nil, err
+	}
+
+	status := sales.StatusRefunded
+	for _, origin := range sold {
+		if origin.returned < origin.qty {
+			status = sales.StatusPartiallyRefunded
+			break
+		}
+	}
+
+	err = sales.SetStatus(ctx, tx, item.SaleID, status)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+
+	return item, nil
+}
+
+// soldPositions - positions of the sale by id with quantity returned before.
+func soldPositions(ctx context.Context, tx pgx.Tx, saleID int64) (map[int64]*soldPosition, error) {
+	sql := `SELECT sp.id, sp.product_id, sp.price, sp.qty, sp.discount, sp.tax, sp.tax_inclusive,
+				   COALESCE ((SELECT SUM (rp.qty) FROM sale_return_positions rp WHERE rp.position_id = sp.id), 0)
+			FROM sale_positions sp
+			WHERE sp.sale_id = $1;`
+	rows, err := tx.Query(ctx, sql, saleID)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	sold := make(map[int64]*soldPosition)
+	for rows.Next() {
+		var id int64
+		position := &soldPosition{}
+		err = rows.Scan(&id, &position.productID, &position.price, &position.qty, &position.discount,
+			&position.tax, &position.taxInclusive, &position.returned)
+		if err != nil {
+			log.Println(err)
+			return nil, ErrInternal
+		}
+		sold[id] = position
+	}
+
+	err = rows.Err()
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+	return sold, nil
+}
+
+// insertReturnPositions - saves the returned positions and puts the products back in stock.
+func insertReturnPositions(ctx context.Context, tx pgx.Tx, item *types.SaleReturn) error {
+	positions := make([]int64, len(item.Positions))
+	products := make([]int64, len(item.Positions))
+	prices := make([]int64, len(item.Positions))
+	qtys := make([]int, len(item.Positions))
+	amounts := make([]int64, len(item.Positions))
+	taxes := make([]int64, len(item.Positions))
+	for i, position := range item.Positions {
+		positions[i] = position.PositionID
+		products[i] = position.ProductID
+		prices[i] = position.Price.Amount
+		qtys[i] = position.Qty
+		amounts[i] = position.Amount.Amount
+		taxes[i] = position.Tax.Amount
+	}
+
+	sql := `INSERT INTO sale_return_positions (return_id, position_id, product_id, price, qty, amount, tax)
+			SELECT $1, v.position_id, v.product_id, v.price, v.qty, v.amount, v.tax
+			FROM unnest($2::BIGINT[], $3::BIGINT[], $4::BIGINT[], $5::INTEGER[], $6::BIGINT[], $7::BIGINT[])
+				 WITH ORDINALITY v (position_id, product_id, price, qty, amount, tax, n)
+			ORDER BY v.n
+			RETURNING id, created;`
+	rows, err := tx.Query(ctx, sql, item.ID, positions, products, prices, qtys, amounts, taxes)
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+
+	for _, position := range item.Positions {
+		if !rows.Next() {
+			break
+		}
+		err = rows.Scan(&position.ID, &position.Created)
+		if err != nil {
+			rows.Close()
+			log.Println(err)
+			return ErrInternal
+		}
+	}
+	rows.Close()
+
+	err = rows.Err()
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+
+	sql = `UPDATE products p SET qty = p.qty + v.qty, version = p.version + 1
+		   FROM (SELECT product_id, SUM (qty) qty
+				 FROM unnest($1::BIGINT[], $2::INTEGER[]) r (product_id, qty)
+				 GROUP BY product_id) v
+		   WHERE p.id = v.product_id;`
+	_, err = tx.Exec(ctx, sql, products, qtys)
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+	return nil
+}
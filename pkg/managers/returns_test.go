@@ -0,0 +1,108 @@
+package managers
+
+import "testing"
+
+func TestShare(t *testing.T) {
+	tests := []struct {
+		name    string
+		sum     int64
+		total   int
+		returns []int
+		want    []int64
+	}{
+		{"whole", 1000, 3, []int{3}, []int64{1000}},
+		{"one by one", 1000, 3, []int{1, 1, 1}, []int64{333, 333, 334}},
+		{"two then one", 1000, 3, []int{2, 1}, []int64{666, 334}},
+		{"one then two", 1000, 3, []int{1, 2}, []int64{333, 667}},
+		{"less than units", 2, 3, []int{1, 1, 1}, []int64{0, 1, 1}},
+		{"even", 900, 3, []int{1, 1, 1}, []int64{300, 300, 300}},
+		{"zero", 0, 4, []int{1, 3}, []int64{0, 0}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			returned, sum := 0, int64(0)
+			for i, qty := range test.returns {
+				got := share(test.sum, returned, qty, test.total)
+				if got != test.want[i] {
+					t.Errorf("share(%d, %d, %d, %d) = %d, want %d", test.sum, returned, qty, test.total, got, test.want[i])
+				}
+				returned += qty
+				sum += got
+			}
+			if returned == test.total && sum != test.sum {
+				t.Errorf("parts add up to %d, want %d", sum, test.sum)
+			}
+		})
+	}
+}
+
+func TestSoldPositionRefund(t *testing.T) {
+	tests := []struct {
+		name       string
+		position   soldPosition
+		returns    []int
+		wantAmount []int64
+		wantTax    []int64
+	}{
+		{
+			name:       "exclusive tax",
+			position:   soldPosition{price: 1000, qty: 3, tax: 360},
+			returns:    []int{1, 1, 1},
+			wantAmount: []int64{1120, 1120, 1120},
+			wantTax:    []int64{120, 120, 120},
+		},
+		{
+			name:       "uneven discount and exclusive tax",
+			position:   soldPosition{price: 333, qty: 3, discount: 100, tax: 108},
+			returns:    []int{1, 1, 1},
+			wantAmount: []int64{335, 336, 336},
+			wantTax:    []int64{36, 36, 36},
+		},
+		{
+			name:       "inclusive tax",
+			position:   soldPosition{price: 1000, qty: 7, discount: 50, tax: 745, taxInclusive: true},
+			returns:    []int{2, 4, 1},
+			wantAmount: []int64{1985, 3972, 993},
+			wantTax:    []int64{212, 426, 107},
+		},
+		{
+			name:       "partial return",
+			position:   soldPosition{price: 999, qty: 4, discount: 1, tax: 479, taxInclusive: true},
+			returns:    []int{3},
+			wantAmount: []int64{2996},
+			wantTax:    []int64{359},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			position := test.position
+			var amounts, taxes int64
+			for i, qty := range test.returns {
+				amount, tax := position.refund(qty)
+				if amount != test.wantAmount[i] || tax != test.wantTax[i] {
+					t.Errorf("refund(%d) after %d = (%d, %d), want (%d, %d)",
+						qty, position.returned, amount, tax, test.wantAmount[i], test.wantTax[i])
+				}
+				position.returned += qty
+				amounts += amount
+				taxes += tax
+			}
+			if position.returned != position.qty {
+				return
+			}
+
+			paid := position.price*int64(position.qty) - position.discount
+			if !position.taxInclusive {
+				paid += position.tax
+			}
+			if amounts != paid {
+				t.Errorf("refunds add up to %d, want paid %d", amounts, paid)
+			}
+			if taxes != position.tax {
+				t.Errorf("refunded taxes add up to %d, want %d", taxes, position.tax)
+			}
+		})
+	}
+}
@@ -0,0 +1,350 @@
+package managers
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+
+	"github.com/SardorMS/CRUD/pkg/types"
+)
+
+// Permissions of managers.
+const (
+	PermProductsRead    = "products:read"
+	PermProductsWrite   = "products:write"
+	PermCustomersRead   = "customers:read"
+	PermCustomersWrite  = "customers:write"
+	PermCustomersDelete = "customers:delete"
+	PermSalesRead       = "sales:read"
+	PermSalesReadAll    = "sales:read:all"
+	PermSalesWrite      = "sales:write"
+	PermSalesOverride   = "sales:price:override"
+	PermSalesVoid       = "sales:void"
+	PermReportsExport   = "reports:export"
+	PermManagersWrite   = "managers:write"
+	PermPromotionsRead  = "promotions:read"
+	PermPromotionsWrite = "promotions:write"
+)
+
+// Permissions - all known permissions.
+var Permissions = []string{
+	PermProductsRead,
+	PermProductsWrite,
+	PermCustomersRead,
+	PermCustomersWrite,
+	PermCustomersDelete,
+	PermSalesRead,
+	PermSalesReadAll,
+	PermSalesWrite,
+	PermSalesOverride,
+	PermSalesVoid,
+	PermReportsExport,
+	PermManagersWrite,
+	PermPromotionsRead,
+	PermPromotionsWrite,
+}
+
+// adminRole - built-in role, which can't be changed or removed.
+const adminRole = "ADMIN"
+
+// ManagerPermissions - returns the permissions granted to manager by the roles.
+func (s *Service) ManagerPermissions(ctx context.Context, id int64) ([]string, error) {
+
+	items := make([]string, 0)
+	sql := `SELECT DISTINCT rp.permission 
+			FROM managers_roles mr
+			JOIN roles_permissions rp ON rp.role = mr.role
+			WHERE mr.manager_id = $1;`
+	rows, err := s.pool.Query(ctx, sql, id)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item string
+		if err = rows.Scan(&item); err != nil {
+			log.Println(err)
+			return nil, ErrInternal
+		}
+		items = append(items, item)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+
+	return items, nil
+}
+
+// Roles - shows all roles with their permissions.
+func (s *Service) Roles(ctx context.Context) ([]*types.Role, error) {
+
+	items := make([]*types.Role, 0)
+	sql := `SELECT r.name, r.description, r.created, 
+				COALESCE (ARRAY_AGG (rp.permission ORDER BY rp.permission) 
+					FILTER (WHERE rp.permission IS NOT NULL), '{}')
+			FROM roles r
+			LEFT JOIN roles_permissions rp ON rp.role = r.name
+			GROUP BY r.name
+			ORDER BY r.name;`
+	rows, err := s.pool.Query(ctx, sql)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := &types.Role{}
+		err = rows.Scan(&item.Name, &item.Description, &item.Created, &item.Permissions)
+		if err != nil {
+			log.Println(err)
+			return nil, ErrInternal
+		}
+		items = append(items, item)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+
+	return items, nil
+}
+
+// CreateRole - creates a new role.
+func (s *Service) CreateRole(ctx context.Context, role *types.Role) (*types.Role, error) {
+
+	if role.Name == "" {
+		return nil, ErrInvalidRole
+	}
+
+	if err := checkPermissions(role.Permissions); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	sql := `INSERT INTO roles (name, description) VALUES ($1, $2) 
+			ON CONFLICT (name) DO NOTHING RETURNING created;`
+	err = tx.QueryRow(ctx, sql, role.Name, role.Description).Scan(&role.Created)
+	if err == pgx.ErrNoRows {
+		return nil, ErrRoleExists
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+
+	if err = setPermissions(ctx, tx, role.Name, role.Permissions); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+	return role, nil
+}
+
+// ChangeRole - changes description and permissions of the role.
+func (s *Service) ChangeRole(ctx context.Context, role *types.Role) (*types.Role, error) {
+
+	if role.Name == adminRole {
+		return nil, ErrRoleProtected
+	}
+
+	if err := checkPermissions(role.Permissions); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	sql := `UPDATE roles SET description = $2 WHERE name = $1 RETURNING created;`
+	err = tx.QueryRow(ctx, sql, role.Name, role.Description).Scan(&role.Created)
+	if err == pgx.ErrNoRows {
+		return nil, ErrRoleNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+
+	if err = setPermissions(ctx, tx, role.Name, role.Permissions); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+	return role, nil
+}
+
+// RemoveRole - removes the role, managers lose it. The default role can't be removed,
+// it's given to registred managers.
+func (s *Service) RemoveRole(ctx context.Context, name string) error {
+
+	if name == adminRole {
+		return ErrRoleProtected
+	}
+	if name == defaultRole {
+		return ErrDefaultRole
+	}
+
+	sql := `DELETE FROM roles WHERE name = $1;`
+	tag, err := s.pool.Exec(ctx, sql, name)
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrRoleNotFound
+	}
+	return nil
+}
+
+// SetManagerRoles - replaces the roles of manager, the last admin can't lose admin role.
+func (s *Service) SetManagerRoles(ctx context.Context, id int64, roles []string) error {
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	var exists bool
+	sql1 := `SELECT EXISTS (SELECT FROM managers WHERE id = $1);`
+	if err = tx.QueryRow(ctx, sql1, id).Scan(&exists); err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+	if !exists {
+		return ErrNoSuchUser
+	}
+
+	if !hasRole(roles, adminRole) {
+		if err = keepAdmin(ctx, tx, id); err != nil {
+			return err
+		}
+	}
+
+	sql2 := `DELETE FROM managers_roles WHERE manager_id = $1;`
+	if _, err = tx.Exec(ctx, sql2, id); err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+
+	if err = insertRoles(ctx, tx, id, roles); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+	return nil
+}
+
+// keepAdmin - checks that other manager stays admin when the manager loses admin role.
+// Admins are locked, so concurrent changes of roles can't leave no admin.
+func keepAdmin(ctx context.Context, tx pgx.Tx, id int64) error {
+
+	var admin bool
+	var others int
+	sql := `SELECT COALESCE (BOOL_OR (manager_id = $2), FALSE), COUNT (*) FILTER (WHERE manager_id <> $2)
+			FROM (SELECT manager_id FROM managers_roles WHERE role = $1 FOR UPDATE) a;`
+	if err := tx.QueryRow(ctx, sql, adminRole, id).Scan(&admin, &others); err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+
+	if admin && others == 0 {
+		return ErrLastAdmin
+	}
+	return nil
+}
+
+// hasRole - checks that the role is in the list.
+func hasRole(roles []string, role string) bool {
+	for _, item := range roles {
+		if item == role {
+			return true
+		}
+	}
+	return false
+}
+
+// insertRoles - assigns roles to manager.
+func insertRoles(ctx context.Context, tx pgx.Tx, id int64, roles []string) error {
+
+	sql := `INSERT INTO managers_roles (manager_id, role) 
+		SELECT $1, UNNEST ($2::TEXT[]) ON CONFLICT DO NOTHING;`
+	_, err := tx.Exec(ctx, sql, id, roles)
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == foreignKeyViolation {
+		return ErrRoleNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+	return nil
+}
+
+// setPermissions - replaces the permissions of role.
+func setPermissions(ctx context.Context, tx pgx.Tx, role string, permissions []string) error {
+
+	sql1 := `DELETE FROM roles_permissions WHERE role = $1;`
+	if _, err := tx.Exec(ctx, sql1, role); err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+
+	sql2 := `INSERT INTO roles_permissions (role, permission) 
+		SELECT $1, UNNEST ($2::TEXT[]) ON CONFLICT DO NOTHING;`
+	if _, err := tx.Exec(ctx, sql2, role, permissions); err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+	return nil
+}
+
+// checkPermissions - verifies that all permissions are known.
+func checkPermissions(permissions []string) error {
+	for _, permission := range permissions {
+		known := false
+		for _, item := range Permissions {
+			if item == permission {
+				known = true
+				break
+			}
+		}
+
+		if !known {
+			return ErrUnknownPermission
+		}
+	}
+	return nil
+}
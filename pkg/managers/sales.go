@@ -0,0 +1,377 @@
+package managers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+
+	"github.com/SardorMS/CRUD/pkg/sales"
+	"github.com/SardorMS/CRUD/pkg/types"
+)
+
+// saleSorts - allowed orders of sales list.
+var saleSorts = map[string]string{
+	"id":       "s.id",
+	"-id":      "s.id DESC",
+	"created":  "s.created, s.id",
+	"-created": "s.created DESC, s.id DESC",
+	"total":    "t.total, s.id",
+	"-total":   "t.total DESC, s.id DESC",
+}
+
+// saleTotals - total and tax of the sale s in its currency without refunds of the returns,
+// the same for the list of sales and the totals of manager.
+const saleTotals = `SELECT COALESCE ((SELECT SUM (sp.price * sp.qty - sp.discount + CASE WHEN sp.tax_inclusive THEN 0 ELSE sp.tax END)
+							   FROM sale_positions sp WHERE sp.sale_id = s.id), 0)::BIGINT
+				   - COALESCE ((SELECT SUM (r.amount)
+							   FROM sale_returns r WHERE r.sale_id = s.id), 0)::BIGINT total,
+				   COALESCE ((SELECT SUM (sp.tax)
+							   FROM sale_positions sp WHERE sp.sale_id = s.id), 0)::BIGINT
+				   - COALESCE ((SELECT SUM (rp.tax)
+							   FROM sale_returns r JOIN sale_return_positions rp ON rp.return_id = r.id
+							   WHERE r.sale_id = s.id), 0)::BIGINT tax`
+
+// ErrInvalidSort - returned when sales are ordered by unknown field.
+var ErrInvalidSort = errors.New("invalid sort")
+
+// paidStatuses - statuses of sales which are counted in totals.
+var paidStatuses = []string{sales.StatusPaid, sales.StatusPartiallyRefunded, sales.StatusRefunded}
+
+// ListSales - searches the sales by filter, positions aren't loaded.
+func (s *Service) ListSales(ctx context.Context, filter *types.SaleFilter) ([]*types.Sale, error) {
+
+	order := saleSorts["-created"]
+	if filter.Sort != "" {
+		var ok bool
+		order, ok = saleSorts[filter.Sort]
+		if !ok {
+			return nil, ErrInvalidSort
+		}
+	}
+
+	conditions := make([]string, 0)
+	args := make([]interface{}, 0)
+	if filter.From != nil {
+		args = append(args, *filter.From)
+		conditions = append(conditions, fmt.Sprintf("s.created >= $%d", len(args)))
+	}
+	if filter.To != nil {
+		args = append(args, *filter.To)
+		conditions = append(conditions, fmt.Sprintf("s.created < $%d", len(args)))
+	}
+	if filter.CustomerID != 0 {
+		args = append(args, filter.CustomerID)
+		conditions = append(conditions, fmt.Sprintf("s.customer_id = $%d", len(args)))
+	}
+	if filter.ManagerID != 0 {
+		args = append(args, filter.ManagerID)
+		conditions = append(conditions, fmt.Sprintf("s.manager_id = $%d", len(args)))
+	}
+	if filter.Status != "" {
+		args = append(args, filter.Status)
+		conditions = append(conditions, fmt.Sprintf("s.status = $%d", len(args)))
+	}
+	if filter.Currency != "" {
+		args = append(args, filter.Currency)
+		conditions = append(conditions, fmt.Sprintf("s.currency = $%d", len(args)))
+	}
+	if filter.ProductID != 0 {
+		args = append(args, filter.ProductID)
+		conditions = append(conditions, fmt.Sprintf(
+			"EXISTS (SELECT 1 FROM sale_positions sp WHERE sp.sale_id = s.id AND sp.product_id = $%d)", len(args)))
+	}
+	if filter.MinTotal != nil {
+		args = append(args, *filter.MinTotal)
+		conditions = append(conditions, fmt.Sprintf("t.total >= $%d", len(args)))
+	}
+	if filter.MaxTotal != nil {
+		args = append(args, *filter.MaxTotal)
+		conditions = append(conditions, fmt.Sprintf("t.total <= $%d", len(args)))
+	}
+
+	where := ""
+	if len(conditions) != 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	args = append(args, filter.Limit, filter.Offset)
+	sql := `SELECT s.id, COALESCE (s.manager_id, 0), s.customer_id, s.status, s.currency, t.tax, t.total,
+				   COALESCE ((SELECT SUM (p.amount) FROM payments p WHERE p.sale_id = s.id), 0)::BIGINT,
+				   COALESCE ((SELECT SUM (r.amount) FROM refunds r WHERE r.sale_id = s.id), 0)::BIGINT, s.created
+			FROM sales s
+			JOIN LATERAL (` + saleTotals + `) t ON TRUE ` +
+		where + fmt.Sprintf(` ORDER BY %s LIMIT $%d OFFSET $%d;`, order, len(args)-1, len(args))
+
+	rows, err := s.pool.Query(ctx, sql, args...)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	items := make([]*types.Sale, 0)
+	for rows.Next() {
+		item := &types.Sale{}
+		err = rows.Scan(&item.ID, &item.ManagerID, &item.CustomerID, &item.Status, &item.Currency,
+			&item.Tax.Amount, &item.Total.Amount, &item.Paid.Amount, &item.Refunded.Amount, &item.Created)
+		if err != nil {
+			log.Println(err)
+			return nil, ErrInternal
+		}
+		item.Tax.Currency = item.Currency
+		item.Total.Currency = item.Currency
+		item.Paid.Currency = item.Currency
+		item.Refunded.Currency = item.Currency
+		items = append(items, item)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+
+	return items, nil
+}
+
+// lockSale - locks the sale, if managerID isn't 0 only the sale made by this manager is found.
+func lockSale(ctx context.Context, tx pgx.Tx, id int64, managerID int64) (*types.Sale, error) {
+	sale, err := sales.Lock(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if managerID != 0 && sale.ManagerID != managerID {
+		return nil, sales.ErrNotFound
+	}
+	return sale, nil
+}
+
+// SaleByID - returns the sale with positions and payments, if managerID isn't 0
+// only the sale made by this manager is found.
+func (s *Service) SaleByID(ctx context.Context, id int64, managerID int64) (*types.Sale, error) {
+	item := &types.Sale{}
+
+	sql := `SELECT id, COALESCE (manager_id, 0), customer_id, status, currency, created FROM sales
+			WHERE id = $1 AND ($2::BIGINT = 0 OR manager_id = $2);`
+	err := s.pool.QueryRow(ctx, sql, id, managerID).Scan(&item.ID, &item.ManagerID, &item.CustomerID, &item.Status, &item.Currency, &item.Created)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+	item.Tax = types.NewMoney(0, item.Currency)
+	item.Total = types.NewMoney(0, item.Currency)
+	item.Paid = types.NewMoney(0, item.Currency)
+	item.Refunded = types.NewMoney(0, item.Currency)
+
+	sql = `SELECT sp.id, sp.product_id, p.name, sp.price, sp.qty, sp.discount, COALESCE (sp.promotion_id, 0),
+				  sp.tax, sp.tax_rate, sp.tax_inclusive, sp.created
+		   FROM sale_positions sp
+		   JOIN products p ON p.id = sp.product_id
+		   WHERE sp.sale_id = $1
+		   ORDER BY sp.id;`
+	rows, err := s.pool.Query(ctx, sql, id)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		position := &types.SalePosition{SaleID: id}
+		err = rows.Scan(
+			&position.ID,
+			&position.ProductID,
+			&position.Name,
+			&position.Price.Amount,
+			&position.Qty,
+			&position.Discount.Amount,
+			&position.PromotionID,
+			&position.Tax.Amount,
+			&position.TaxRate,
+			&position.TaxInclusive,
+			&position.Created)
+		if err != nil {
+			log.Println(err)
+			return nil, ErrInternal
+		}
+		position.Price.Currency = item.Currency
+		position.Discount.Currency = item.Currency
+		position.Tax.Currency = item.Currency
+		position.Sum = position.Price.Mul(int64(position.Qty)).Sub(position.Discount)
+		if !position.TaxInclusive {
+			position.Sum = position.Sum.Add(position.Tax)
+		}
+		item.Tax = item.Tax.Add(position.Tax)
+		item.Total = item.Total.Add(position.Sum)
+		item.Positions = append(item.Positions, position)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+
+	item.Payments, err = s.payments(ctx, id, item.Currency)
+	if err != nil {
+		return nil, err
+	}
+	for _, payment := range item.Payments {
+		item.Paid = item.Paid.Add(payment.Amount)
+	}
+
+	item.Refunds, err = s.refunds(ctx, id, item.Currency)
+	if err != nil {
+		return nil, err
+	}
+	for _, refund := range item.Refunds {
+		item.Refunded = item.Refunded.Add(refund.Amount)
+	}
+
+	return item, nil
+}
+
+// AddPayments - pays the pending sale by one or several methods. If ownerID isn't 0
+// only the sale made by this manager is found.
+func (s *Service) AddPayments(ctx context.Context, id int64, payments []*types.Payment, managerID int64, ownerID int64) (*types.Sale, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = lockSale(ctx, tx, id, ownerID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = sales.Pay(ctx, tx, id, payments, managerID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+
+	return s.SaleByID(ctx, id, 0)
+}
+
+// VoidSale - cancels pending or paid sale, its products are put back in stock and its payments
+// are refunded by the manager. If ownerID isn't 0 only the sale made by this manager is found.
+func (s *Service) VoidSale(ctx context.Context, id int64, managerID int64, ownerID int64) (*types.Sale, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = lockSale(ctx, tx, id, ownerID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = sales.Void(ctx, tx, id, managerID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+
+	return s.SaleByID(ctx, id, 0)
+}
+
+// payments - payments of the sale in its currency.
+func (s *Service) payments(ctx context.Context, id int64, currency string) ([]*types.Payment, error) {
+	sql := `SELECT id, sale_id, method, amount, tendered, change, COALESCE (manager_id, 0), created
+			FROM payments WHERE sale_id = $1 ORDER BY id;`
+	rows, err := s.pool.Query(ctx, sql, id)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	items := make([]*types.Payment, 0)
+	for rows.Next() {
+		item := &types.Payment{}
+		err = rows.Scan(
+			&item.ID,
+			&item.SaleID,
+			&item.Method,
+			&item.Amount.Amount,
+			&item.Tendered.Amount,
+			&item.Change.Amount,
+			&item.ManagerID,
+			&item.Created)
+		if err != nil {
+			log.Println(err)
+			return nil, ErrInternal
+		}
+		item.Amount.Currency = currency
+		item.Tendered.Currency = currency
+		item.Change.Currency = currency
+		items = append(items, item)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+	return items, nil
+}
+
+// refunds - refunds of the sale in its currency.
+func (s *Service) refunds(ctx context.Context, id int64, currency string) ([]*types.Refund, error) {
+	sql := `SELECT id, sale_id, payment_id, COALESCE (return_id, 0), method, amount, COALESCE (manager_id, 0), created
+			FROM refunds WHERE sale_id = $1 ORDER BY id;`
+	rows, err := s.pool.Query(ctx, sql, id)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	items := make([]*types.Refund, 0)
+	for rows.Next() {
+		item := &types.Refund{}
+		err = rows.Scan(
+			&item.ID,
+			&item.SaleID,
+			&item.PaymentID,
+			&item.ReturnID,
+			&item.Method,
+			&item.Amount.Amount,
+			&item.ManagerID,
+			&item.Created)
+		if err != nil {
+			log.Println(err)
+			return nil, ErrInternal
+		}
+		item.Amount.Currency = currency
+		items = append(items, item)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+	return items, nil
+}
@@ -6,14 +6,23 @@ import (
 	"encoding/hex"
 	"errors"
 	"log"
-	"strconv"
-
+	"strings"
+	"time"
+
+	"github.com/SardorMS/CRUD/pkg/catalog"
+	"github.com/SardorMS/CRUD/pkg/config"
+	"github.com/SardorMS/CRUD/pkg/logins"
+	"github.com/SardorMS/CRUD/pkg/passwords"
+	"github.com/SardorMS/CRUD/pkg/sales"
+	"github.com/SardorMS/CRUD/pkg/tokens"
 	"github.com/SardorMS/CRUD/pkg/types"
-	"github.com/jackc/pgx"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"golang.org/x/crypto/bcrypt"
 )
 
+
 var (
 	ErrInternal        = errors.New("internal error")          //return when an internal error occurred.
 	ErrNoSuchUser      = errors.New("no such user")            //return no such user.
@@ -22,16 +31,53 @@ var (
 	ErrTokenNotFound   = errors.New("token not found")         //retrun when token not found.
 	ErrTokenExpired    = errors.New("token expired")           //return when token expired
 	ErrNotFound        = errors.New("not found")               // return not found
+	ErrVersionMismatch = errors.New("version mismatch")        // return when the record was changed by other request.
+
+	ErrRoleNotFound      = errors.New("role not found")           // return when role doesn't exist.
+	ErrRoleExists        = errors.New("role already exists")      // return when role name is used.
+	ErrRoleProtected     = errors.New("role can't be changed")    // return on change of built-in role.
+	ErrRoleForbidden     = errors.New("role can't be granted")    // return when registrar can't grant the role.
+	ErrLastAdmin         = errors.New("last admin")               // return when no manager would be left with admin role.
+	ErrDefaultRole       = errors.New("default role")             // return on removal of the role given at registration.
+	ErrInvalidRole       = errors.New("invalid role")             // return when role has no name.
+	ErrUnknownPermission = errors.New("unknown permission")       // return when permission isn't known.
+	ErrInvalidCode       = errors.New("invalid setup code")       // return when setup code is wrong or used.
+	ErrCategoryExists    = errors.New("category already exists")  // return when category name is used.
+	ErrPromoCodeUsed     = errors.New("promo code already used")  // return when promo code belongs to other promotion.
+	ErrTaxClassExists    = errors.New("tax class already exists") // return when tax class name is used.
+	ErrReferenced        = errors.New("record is referenced")     // return when purged record is used by other records.
 )
 
+// foreignKeyViolation - postgres error code of violated foreign key.
+const foreignKeyViolation = "23503"
+
+// defaultRole - role of manager registred without roles.
+const defaultRole = "MANAGER"
+
 //Service - describes managers service.
 type Service struct {
-	pool *pgxpool.Pool
+	pool         *pgxpool.Pool
+	logins       *logins.Service
+	signed       *tokens.Service
+	tokens       config.Tokens
+	passwords    passwords.Policy
+	setupCodeTTL time.Duration
+	maxDeviation int
+	pricing      sales.Pricing
 }
 
 //newService - create a service.
-func NewService(pool *pgxpool.Pool) *Service {
-	return &Service{pool: pool}
+func NewService(pool *pgxpool.Pool, loginsSvc *logins.Service, tokensSvc *tokens.Service, cfg *config.Config) *Service {
+	return &Service{
+		pool:         pool,
+		logins:       loginsSvc,
+		signed:       tokensSvc,
+		tokens:       cfg.Managers,
+		passwords:    passwords.Policy{MinLength: cfg.MinPasswordLength},
+		setupCodeTTL: cfg.SetupCodeTTL,
+		maxDeviation: cfg.MaxPriceDeviation,
+		pricing:      sales.Pricing{Base: cfg.Currency, Currencies: cfg.Currencies},
+	}
 }
 
 // IDByToken - performs the users authentication procedure,
@@ -39,236 +85,681 @@ func NewService(pool *pgxpool.Pool) *Service {
 func (s *Service) IDByToken(ctx context.Context, token string) (int64, error) {
 
 	var id int64
-	sql := `SELECT manager_id FROM managers_tokens WHERE token = $1;`
-	err := s.pool.QueryRow(ctx, sql, token).Scan(&id)
+	var expired bool
+	sql := `UPDATE managers_tokens SET last_used = CURRENT_TIMESTAMP WHERE token = $1 
+			RETURNING manager_id, expire <= CURRENT_TIMESTAMP;`
+	err := s.pool.QueryRow(ctx, sql, token).Scan(&id, &expired)
+
+	if err == pgx.ErrNoRows {
+		return 0, ErrTokenNotFound
+	}
 
 	if err != nil {
 		log.Println(err)
-		if err == pgx.ErrNoRows {
-			return 0, nil
-		}
-		return 0, nil
+		return 0, ErrInternal
+	}
+
+	if expired {
+		return 0, ErrTokenExpired
 	}
 	return id, nil
 }
 
-// IsAdmin - managers role check.
-func (s *Service) IsAdmin(ctx context.Context, id int64) (isAdmin bool) {
-	sql := `SELECT is_admin FROM managers WHERE id = $1`
-	err := s.pool.QueryRow(ctx, sql, id).Scan(&isAdmin)
+// HasAnyRole - checks that manager has any of roles.
+func (s *Service) HasAnyRole(ctx context.Context, id int64, roles ...string) bool {
+
+	var has bool
+	sql := `SELECT EXISTS (SELECT FROM managers_roles WHERE manager_id = $1 AND role = ANY ($2));`
+	err := s.pool.QueryRow(ctx, sql, id, roles).Scan(&has)
 	if err != nil {
 		log.Println(err)
 		return false
 	}
-	return
+	return has
 }
 
-// Register - managers register procedure.
-func (s *Service) Register(ctx context.Context, item *types.Managers) (string, error) {
+// Register - managers register procedure, returns one-time code
+// by which the manager sets up his password. Roles other than the default one
+// can be granted only by admin.
+func (s *Service) Register(ctx context.Context, registrarID int64, item *types.Managers) (*types.SetupCode, error) {
 
-	var token string
 	var id int64
 
-	sql1 := `INSERT INTO managers (name, phone, is_admin) 
-		VALUES ($1, $2, $3) ON CONFLICT (phone) DO NOTHING RETURNING id;`
-	err := s.pool.QueryRow(ctx, sql1, item.Name, item.Phone, item.IsAdmin).Scan(&id)
+	roles := item.Roles
+	if len(roles) == 0 {
+		roles = []string{defaultRole}
+	}
+	for _, role := range roles {
+		if role != defaultRole && !s.HasAnyRole(ctx, registrarID, adminRole) {
+			return nil, ErrRoleForbidden
+		}
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	sql := `INSERT INTO managers (name, phone) 
+		VALUES ($1, $2) ON CONFLICT (phone) DO NOTHING RETURNING id;`
+	err = tx.QueryRow(ctx, sql, item.Name, item.Phone).Scan(&id)
+	if err == pgx.ErrNoRows {
+		return nil, ErrPhoneUsed
+	}
 	if err != nil {
 		log.Print(err)
-		return "", ErrInternal
+		return nil, ErrInternal
 	}
 
-	buffer := make([]byte, 256)
-	n, err := rand.Read(buffer)
-	if n != len(buffer) || err != nil {
-		return "", ErrInternal
+	if err = insertRoles(ctx, tx, id, roles); err != nil {
+		return nil, err
 	}
-	token = hex.EncodeToString(buffer)
 
-	sql2 := `INSERT INTO managers_tokens (token, manager_id) VALUES($1, $2);`
-	_, err = s.pool.Exec(ctx, sql2, token, id)
+	code, err := s.insertSetupCode(ctx, tx, id)
 	if err != nil {
-		log.Println(err)
-		return "", ErrInternal
+		return nil, err
 	}
-	return token, nil
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	return code, nil
 }
 
 // Tokern - generates a token for managers.
-func (s *Service) Token(ctx context.Context, phone string, password string) (token string, err error) {
+func (s *Service) Token(ctx context.Context, phone string, password string, client *types.Client) (*types.Token, error) {
 
 	var id int64
-	var hash string
+	var hash *string
+
+	err := s.logins.Check(ctx, logins.KindManager, phone, client.IP)
+	if err != nil {
+		return nil, err
+	}
 
-	sql1 := `SELECT id, password FROM managers WHERE phone = $1;`
-	err = s.pool.QueryRow(ctx, sql1, phone).Scan(&id, &hash)
+	sql := `SELECT id, password FROM managers WHERE phone = $1;`
+	err = s.pool.QueryRow(ctx, sql, phone).Scan(&id, &hash)
 
 	if err == pgx.ErrNoRows {
-		return "", ErrNoSuchUser
+		if err = s.logins.Failed(ctx, logins.KindManager, phone, client); err != nil {
+			return nil, err
+		}
+		return nil, ErrNoSuchUser
 	}
 
 	if err != nil {
-		return "", ErrInternal
+		log.Println(err)
+		return nil, ErrInternal
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if hash == nil || bcrypt.CompareHashAndPassword([]byte(*hash), []byte(password)) != nil {
+		if err = s.logins.Failed(ctx, logins.KindManager, phone, client); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidPassword
+	}
+
+	if err = s.logins.Succeeded(ctx, logins.KindManager, phone, id, client); err != nil {
+		return nil, err
+	}
+
+	return s.issueToken(ctx, id, client)
+}
+
+// RefreshToken - exchanges the refresh token for a new pair of tokens, the replaced token is revoked.
+func (s *Service) RefreshToken(ctx context.Context, refresh string, client *types.Client) (*types.Token, error) {
+
+	var id int64
+	var managerID int64
+	var expired bool
+	var replaced string
+	item := &types.Token{}
+
+	tx, err := s.pool.Begin(ctx)
 	if err != nil {
-		return "", ErrInvalidPassword
+		log.Println(err)
+		return nil, ErrInternal
 	}
+	defer tx.Rollback(ctx)
 
-	buffer := make([]byte, 256)
-	n, err := rand.Read(buffer)
-	if n != len(buffer) || err != nil {
-		return "", ErrInternal
+	sql := `SELECT id, manager_id, token, refresh_expire <= CURRENT_TIMESTAMP FROM managers_tokens 
+			WHERE refresh = $1 FOR UPDATE;`
+	err = tx.QueryRow(ctx, sql, refresh).Scan(&id, &managerID, &replaced, &expired)
+	if err == pgx.ErrNoRows {
+		return nil, ErrTokenNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+
+	if expired {
+		return nil, ErrTokenExpired
 	}
 
-	token = hex.EncodeToString(buffer)
-	sql2 := `INSERT INTO managers_tokens (token, manager_id) VALUES($1, $2);`
-	_, err = s.pool.Exec(ctx, sql2, token, id)
+	item.Token, err = s.accessToken(ctx, managerID)
 	if err != nil {
-		return "", ErrInternal
+		return nil, err
+	}
+
+	item.RefreshToken, err = generateToken()
+	if err != nil {
+		return nil, err
+	}
+
+	sql = `UPDATE managers_tokens 
+			SET token = $2, expire = CURRENT_TIMESTAMP + $3 * INTERVAL '1 second', 
+				refresh = $4, refresh_expire = CURRENT_TIMESTAMP + $5 * INTERVAL '1 second',
+				user_agent = $6, ip = $7, last_used = CURRENT_TIMESTAMP
+			WHERE id = $1
+			RETURNING expire, refresh_expire;`
+	err = tx.QueryRow(ctx, sql, id,
+		item.Token, s.tokens.TTL.Seconds(),
+		item.RefreshToken, s.tokens.RefreshTTL.Seconds(),
+		client.UserAgent, client.IP).Scan(&item.Expire, &item.RefreshExpire)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Println(err)
+		return nil, ErrInternal
 	}
-	return token, nil
+
+	// replaced signed token stays valid by signature until it expires.
+	if err = s.revokeSigned(ctx, []string{replaced}); err != nil {
+		return nil, err
+	}
+	return item, nil
 }
 
-// GetSales - get the sales information.
-func (s *Service) GetSales(ctx context.Context, id int64) (sum int, err error) {
+// issueToken - saves a new pair of tokens for manager.
+func (s *Service) issueToken(ctx context.Context, id int64, client *types.Client) (*types.Token, error) {
 
-	sql := `SELECT COALESCE (SUM (sp.price * sp.qty), 0) total
-			FROM managers m
-			LEFT JOIN sales s ON s.manager_id = $1
-			LEFT JOIN sale_positions sp ON sp.sale_id = s.id
-			GROUP BY m.id
-			LIMIT 1;`
-	err = s.pool.QueryRow(ctx, sql, id).Scan(&sum)
+	var err error
+	item := &types.Token{}
 
+	item.Token, err = s.accessToken(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	item.RefreshToken, err = generateToken()
+	if err != nil {
+		return nil, err
+	}
+
+	sql := `INSERT INTO managers_tokens (token, manager_id, expire, refresh, refresh_expire, user_agent, ip) 
+			VALUES ($1, $2, CURRENT_TIMESTAMP + $3 * INTERVAL '1 second', 
+				$4, CURRENT_TIMESTAMP + $5 * INTERVAL '1 second', $6, $7)
+			RETURNING expire, refresh_expire;`
+	err = s.pool.QueryRow(ctx, sql, item.Token, id, s.tokens.TTL.Seconds(),
+		item.RefreshToken, s.tokens.RefreshTTL.Seconds(),
+		client.UserAgent, client.IP).Scan(&item.Expire, &item.RefreshExpire)
 	if err != nil {
 		log.Println(err)
-		return 0, ErrInternal
+		return nil, ErrInternal
 	}
-	return sum, nil
+	return item, nil
+}
+
+// RevokeToken - revokes the token (log out).
+func (s *Service) RevokeToken(ctx context.Context, token string) error {
+
+	sql := `DELETE FROM managers_tokens WHERE token = $1;`
+	tag, err := s.pool.Exec(ctx, sql, token)
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrTokenNotFound
+	}
+	return s.revokeSigned(ctx, []string{token})
 }
 
-// MakeSales - makes a sale.
-func (s *Service) MakeSales(ctx context.Context, sale *types.Sale) (*types.Sale, error) {
+// RevokeTokens - revokes all tokens of the manager (log out everywhere).
+func (s *Service) RevokeTokens(ctx context.Context, id int64) error {
+
+	revoked := make([]string, 0)
+	sql := `DELETE FROM managers_tokens WHERE manager_id = $1 RETURNING token;`
+	rows, err := s.pool.Query(ctx, sql, id)
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var token string
+		if err = rows.Scan(&token); err != nil {
+			log.Println(err)
+			return ErrInternal
+		}
+		revoked = append(revoked, token)
+	}
 
-	positionSQL := "INSERT INTO sale_positions (sale_id, product_id, qty, price) VALUES "
+	err = rows.Err()
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+
+	return s.revokeSigned(ctx, revoked)
+}
 
-	sql := `INSERT INTO sales (manager_id, customer_id) VALUES ($1, $2) RETURNING id, created;`
-	err := s.pool.QueryRow(ctx, sql, sale.ManagerID, sale.CustomerID).Scan(&sale.ID, &sale.Created)
+// Sessions - shows active tokens of the manager, token is the current one.
+func (s *Service) Sessions(ctx context.Context, id int64, token string) ([]*types.Session, error) {
 
+	items := make([]*types.Session, 0)
+	sql := `SELECT id, user_agent, ip, token = $2, expire, refresh_expire, last_used, created 
+			FROM managers_tokens
+			WHERE manager_id = $1 AND refresh_expire > CURRENT_TIMESTAMP
+			ORDER BY last_used DESC;`
+	rows, err := s.pool.Query(ctx, sql, id, token)
 	if err != nil {
 		log.Println(err)
 		return nil, ErrInternal
 	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := &types.Session{}
+		err = rows.Scan(
+			&item.ID,
+			&item.UserAgent,
+			&item.IP,
+			&item.Current,
+			&item.Expire,
+			&item.RefreshExpire,
+			&item.LastUsed,
+			&item.Created)
 
-	for _, position := range sale.Positions {
-		if !s.MakeSalePosition(ctx, position) {
-			log.Println("Invalid position")
+		if err != nil {
+			log.Println(err)
 			return nil, ErrInternal
 		}
-		positionSQL += "(" + strconv.FormatInt(sale.ID, 10) + "," +
-			strconv.FormatInt(position.ProductID, 10) + "," +
-			strconv.Itoa(position.Price) + "," + strconv.Itoa(position.Qty) + "),"
+		items = append(items, item)
 	}
 
-	positionSQL = positionSQL[0 : len(positionSQL)-1]
-	log.Println(positionSQL)
-
-	_, err = s.pool.Exec(ctx, positionSQL)
+	err = rows.Err()
 	if err != nil {
 		log.Println(err)
 		return nil, ErrInternal
 	}
 
-	return sale, nil
+	return items, nil
 }
 
-// MakeSalePosition - updates a products information.
-func (s *Service) MakeSalePosition(ctx context.Context, position *types.SalePosition) bool {
-	active := false
-	qty := 0
+// accessToken - generates access token for manager, signed one (with his roles)
+// if signed tokens are enabled.
+func (s *Service) accessToken(ctx context.Context, id int64) (string, error) {
+	if !s.signed.Enabled() {
+		return generateToken()
+	}
 
-	sql1 := `SELECT qty, active FROM products WHERE id = $1;`
-	if err := s.pool.QueryRow(ctx, sql1, position.ProductID).Scan(&qty, &active); err != nil {
-		return false
+	roles := make([]string, 0)
+	sql := `SELECT role FROM managers_roles WHERE manager_id = $1 ORDER BY role;`
+	rows, err := s.pool.Query(ctx, sql, id)
+	if err != nil {
+		log.Println(err)
+		return "", ErrInternal
 	}
+	defer rows.Close()
 
-	if qty < position.Qty || !active {
-		return false
+	for rows.Next() {
+		var role string
+		if err = rows.Scan(&role); err != nil {
+			log.Println(err)
+			return "", ErrInternal
+		}
+		roles = append(roles, role)
 	}
 
-	sql2 := `UPDATE products SET qty = $1 WHERE id = $2`
-	if _, err := s.pool.Exec(ctx, sql2, qty-position.Qty, position.ProductID); err != nil {
+	err = rows.Err()
+	if err != nil {
 		log.Println(err)
-		return false
+		return "", ErrInternal
 	}
-	return true
+
+	token, _, err := s.signed.Issue(tokens.KindManager, id, roles, s.tokens.TTL)
+	return token, err
 }
 
-// Products - shows information about products to customers.
-func (s *Service) Products(ctx context.Context) ([]*types.Products, error) {
+// revokeSigned - adds the signed tokens to deny-list, they stay valid until expiry otherwise.
+func (s *Service) revokeSigned(ctx context.Context, revoked []string) error {
+	if !s.signed.Enabled() {
+		return nil
+	}
 
-	items := make([]*types.Products, 0)
-	sql := `SELECT id, name, price, qty FROM products WHERE active = true ORDER BY id LIMIT 500;`
-	rows, err := s.pool.Query(ctx, sql)
+	for _, token := range revoked {
+		if err := s.signed.Revoke(ctx, token); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	if errors.Is(err, pgx.ErrNoRows) {
-		return items, nil
+// generateToken - generates a random token.
+func generateToken() (string, error) {
+	buffer := make([]byte, 256)
+	n, err := rand.Read(buffer)
+	if n != len(buffer) || err != nil {
+		return "", ErrInternal
 	}
+	return hex.EncodeToString(buffer), nil
+}
+
+// GetSales - get the sales information: total and tax of the sales in the base currency,
+// sales in other currencies are converted by the rates they were made with.
+func (s *Service) GetSales(ctx context.Context, id int64) (total types.Money, tax types.Money, err error) {
+
+	// total and tax of paid sales without refunds of the returns, in the base currency.
+	sql := `SELECT COALESCE (ROUND (SUM (t.total * s.rate)), 0)::BIGINT,
+				   COALESCE (ROUND (SUM (t.tax * s.rate)), 0)::BIGINT
+			FROM sales s
+			JOIN LATERAL (` + saleTotals + `) t ON TRUE
+			WHERE s.manager_id = $1 AND s.status = ANY ($2);`
+	total = types.NewMoney(0, s.pricing.Base)
+	tax = types.NewMoney(0, s.pricing.Base)
+	err = s.pool.QueryRow(ctx, sql, id, paidStatuses).Scan(&total.Amount, &tax.Amount)
 
 	if err != nil {
+		log.Println(err)
+		return total, tax, ErrInternal
+	}
+	return total, tax, nil
+}
+
+// MakeSales - makes a sale by current prices: locks the products, decrements their stock
+// and saves the sale with positions and payments in one transaction. If override is true,
+// prices of positions deviated from current prices within the limit are kept.
+func (s *Service) MakeSales(ctx context.Context, sale *types.Sale, override bool) (*types.Sale, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		log.Println(err)
 		return nil, ErrInternal
 	}
+	defer tx.Rollback(ctx)
+
+	var priceOverride *sales.Override
+	if override {
+		priceOverride = &sales.Override{MaxDeviation: s.maxDeviation}
+	}
+
+	err = sales.Insert(ctx, tx, sale, s.pricing, priceOverride)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sale.Payments) != 0 {
+		paid, err := sales.Pay(ctx, tx, sale.ID, sale.Payments, sale.ManagerID)
+		if err != nil {
+			return nil, err
+		}
+		sale.Status = paid.Status
+		sale.Paid = paid.Paid
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+
+	return sale, nil
+}
+
+// Products - searches the products for managers, returns the page of them.
+func (s *Service) Products(ctx context.Context, filter *types.ProductFilter) ([]*types.Products, *types.Page, error) {
+
+	query, err := catalog.NewQuery(productColumns, filter)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows, err := s.pool.Query(ctx, query.SQL, query.Args...)
+	if err != nil {
+		log.Println(err)
+		return nil, nil, ErrInternal
+	}
 	defer rows.Close()
 
+	items := make([]*types.Products, 0)
+	keys := make([]string, 0)
 	for rows.Next() {
 		item := &types.Products{}
-		err = rows.Scan(&item.ID, &item.Name, &item.Price, &item.Qty)
+		var key string
+		err = scanProduct(rows, item, &key)
 
 		if err != nil {
 			log.Println(err)
-			return nil, err
+			return nil, nil, ErrInternal
 		}
+		item.Price.Currency = s.pricing.Base
 		items = append(items, item)
+		keys = append(keys, key)
 	}
 
 	err = rows.Err()
 	if err != nil {
 		log.Println(err)
+		return nil, nil, ErrInternal
+	}
+
+	page := &types.Page{}
+	if len(items) > query.Limit {
+		items = items[:query.Limit]
+		page.Next = query.Cursor(keys[query.Limit-1], items[query.Limit-1].ID)
+	}
+
+	err = s.pool.QueryRow(ctx, query.CountSQL, query.CountArgs...).Scan(&page.Total)
+	if err != nil {
+		log.Println(err)
+		return nil, nil, ErrInternal
+	}
+
+	err = s.productPrices(ctx, items)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return items, page, nil
+}
+
+// productPrices - sets own prices of the products in other currencies.
+func (s *Service) productPrices(ctx context.Context, products []*types.Products) error {
+	ids := make([]int64, len(products))
+	byID := make(map[int64]*types.Products, len(products))
+	for i, item := range products {
+		ids[i] = item.ID
+		byID[item.ID] = item
+	}
+
+	sql := `SELECT product_id, currency, price FROM product_prices WHERE product_id = ANY ($1) ORDER BY currency;`
+	rows, err := s.pool.Query(ctx, sql, ids)
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var price types.Money
+		err = rows.Scan(&id, &price.Currency, &price.Amount)
+		if err != nil {
+			log.Println(err)
+			return ErrInternal
+		}
+		byID[id].Prices = append(byID[id].Prices, price)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+	return nil
+}
+
+// ProductByID - returns the product with own prices, archived products are shown too.
+func (s *Service) ProductByID(ctx context.Context, id int64) (*types.Products, error) {
+	item := &types.Products{}
+	sql := `SELECT ` + productColumns + ` FROM products WHERE id = $1;`
+	err := scanProduct(s.pool.QueryRow(ctx, sql, id), item)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+	item.Price.Currency = s.pricing.Base
+
+	err = s.productPrices(ctx, []*types.Products{item})
+	if err != nil {
 		return nil, err
 	}
+	return item, nil
+}
 
-	return items, nil
+// CreateProduct - saves the new product. The price is in the base currency, own prices
+// can be given in other currencies.
+func (s *Service) CreateProduct(ctx context.Context, product *types.Products) (*types.Products, error) {
+	product.ID = 0
+	return s.storeProduct(ctx, product, AnyVersion, nil)
 }
 
-// ChangeProduct(Save) - change or save an information about products.
-func (s *Service) ChangeProduct(ctx context.Context, product *types.Products) (*types.Products, error) {
+// ReplaceProduct - replaces all information about the product which isn't archived and has
+// the version, own prices in other currencies replace the saved ones. The stock quantity
+// is kept, it's changed by AdjustStock.
+func (s *Service) ReplaceProduct(ctx context.Context, product *types.Products, version int64) (*types.Products, error) {
+	return s.storeProduct(ctx, product, version, nil)
+}
 
-	var err error
+// PatchProduct - changes only the fields of product with the version given in JSON Merge Patch,
+// null resets the field. The stock quantity is kept, it's changed by AdjustStock.
+func (s *Service) PatchProduct(ctx context.Context, id int64, version int64, changes []byte) (*types.Products, error) {
+	return s.storeProduct(ctx, &types.Products{ID: id}, version, changes)
+}
+
+// AdjustStock - adds qty to the stock of the product which isn't archived, negative qty writes off.
+// The change is relative, so it's made by the current stock and doesn't need the version.
+func (s *Service) AdjustStock(ctx context.Context, id int64, qty int) (*types.Products, error) {
+	if qty == 0 {
+		return nil, &types.ValidationError{Field: "qty", Reason: "zero"}
+	}
+
+	item := &types.Products{}
+	sql := `UPDATE products SET qty = qty + $2, version = version + 1
+			WHERE id = $1 AND deleted_at IS NULL AND qty + $2 >= 0
+			RETURNING ` + productColumns + `;`
+	err := scanProduct(s.pool.QueryRow(ctx, sql, id, qty), item)
+	if errors.Is(err, pgx.ErrNoRows) {
+		var exists bool
+		sql = `SELECT EXISTS (SELECT 1 FROM products WHERE id = $1 AND deleted_at IS NULL);`
+		err = s.pool.QueryRow(ctx, sql, id).Scan(&exists)
+		if err != nil {
+			log.Println(err)
+			return nil, ErrInternal
+		}
+		if !exists {
+			return nil, ErrNotFound
+		}
+		return nil, &types.ValidationError{Field: "qty", Reason: "exceeds_stock"}
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+
+	item.Price.Currency = s.pricing.Base
+	err = s.productPrices(ctx, []*types.Products{item})
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// storeProduct - inserts the product if its id is 0, otherwise updates it if it has the version.
+// If changes are given, they are applied to the saved product. The saved product is locked
+// until the end of transaction, so it can't be changed by other request meanwhile.
+func (s *Service) storeProduct(ctx context.Context, product *types.Products, version int64, changes []byte) (*types.Products, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	if product.ID != 0 {
+		product, err = s.lockProduct(ctx, tx, product, version, changes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = validateCatalog(product)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.validatePrices(product)
+	if err != nil {
+		return nil, err
+	}
 
 	if product.ID == 0 {
-		sql1 := `INSERT INTO products (name, qty, price) VALUES ($1, $2, $3)
-				 RETURNING id, name, qty, price, active, created;`
-		err = s.pool.QueryRow(ctx, sql1, product.Name, product.Qty, product.Price).Scan(
-			&product.ID,
-			&product.Name,
-			&product.Qty,
-			&product.Price,
-			&product.Active,
-			&product.Created)
+		sql1 := `INSERT INTO products (name, category_id, tax_class_id, sku, barcode, description, unit, qty, price, active)
+				 VALUES ($1, NULLIF ($2::BIGINT, 0), NULLIF ($3::BIGINT, 0), NULLIF ($4, ''), NULLIF ($5, ''), $6, $7, $8, $9, $10)
+				 RETURNING ` + productColumns + `;`
+		err = scanProduct(tx.QueryRow(ctx, sql1, product.Name, product.CategoryID, product.TaxClassID, product.SKU, product.Barcode,
+			product.Description, product.Unit, product.Qty, product.Price.Amount, product.Active), product)
 
 	} else {
-		sql2 := `UPDATE products SET name = $1, qty = $2, price = $3 WHERE id = $4 
-				 RETURNING  id, name, qty, price, active, created;`
-		err = s.pool.QueryRow(ctx, sql2, product.Name, product.Qty, product.Price, product.ID).Scan(
-			&product.ID,
-			&product.Name,
-			&product.Qty,
-			&product.Price,
-			&product.Active,
-			&product.Created)
+		// the stock is changed by sales and AdjustStock only, so their changes can't be lost.
+		sql2 := `UPDATE products SET name = $1, category_id = NULLIF ($2::BIGINT, 0), tax_class_id = NULLIF ($3::BIGINT, 0),
+				 sku = NULLIF ($4, ''), barcode = NULLIF ($5, ''), description = $6, unit = $7, price = $8, active = $9,
+				 version = version + 1 WHERE id = $10
+				 RETURNING ` + productColumns + `;`
+		err = scanProduct(tx.QueryRow(ctx, sql2, product.Name, product.CategoryID, product.TaxClassID, product.SKU, product.Barcode,
+			product.Description, product.Unit, product.Price.Amount, product.Active, product.ID), product)
 	}
 
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == foreignKeyViolation {
+		field := "category_id"
+		if pgErr.ConstraintName == "products_tax_class_id_fkey" {
+			field = "tax_class_id"
+		}
+		return nil, &types.ValidationError{Field: field, Reason: "not_found"}
+	}
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+		field := "sku"
+		if pgErr.ConstraintName == "products_barcode_key" {
+			field = "barcode"
+		}
+		return nil, &types.ValidationError{Field: field, Reason: "taken"}
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+
+	err = savePrices(ctx, tx, product)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit(ctx)
 	if err != nil {
 		log.Println(err)
 		return nil, ErrInternal
@@ -277,33 +768,131 @@ func (s *Service) ChangeProduct(ctx context.Context, product *types.Products) (*
 
 }
 
-// RemoveProductByID - remove information about products.
-func (s *Service) RemoveProductByID(ctx context.Context, id int64) (*types.Products, error) {
+// lockProduct - locks the product which isn't archived and has the version. Returns the product
+// to save: the given one or the saved one with the changes applied.
+func (s *Service) lockProduct(ctx context.Context, tx pgx.Tx, product *types.Products, version int64, changes []byte) (*types.Products, error) {
 	item := &types.Products{}
+	sql := `SELECT ` + productColumns + ` FROM products WHERE id = $1 AND deleted_at IS NULL FOR UPDATE;`
+	err := scanProduct(tx.QueryRow(ctx, sql, product.ID), item)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
 
-	sql := `DELETE FROM products WHERE id = $1 RETURNING id, name, price, qty, active, created;`
-	err := s.pool.QueryRow(ctx, sql, id).Scan(
-		&item.ID,
-		&item.Name,
-		&item.Price,
-		&item.Qty,
-		&item.Active,
-		&item.Created)
+	err = checkVersion(item.Version, version)
+	if err != nil {
+		return nil, err
+	}
+	if changes == nil {
+		return product, nil
+	}
+	item.Price.Currency = s.pricing.Base
 
+	// prices can't change meanwhile, they are saved only with the locked product.
+	err = s.productPrices(ctx, []*types.Products{item})
+	if err != nil {
+		return nil, err
+	}
+
+	patched := &types.Products{}
+	err = applyPatch(item, changes, patched)
+	if err != nil {
+		return nil, err
+	}
+	patched.ID = item.ID
+	return patched, nil
+}
+
+// validatePrices - checks that the price is in the base currency and own prices are
+// in the supported currencies, one price for each.
+func (s *Service) validatePrices(product *types.Products) error {
+	if product.Price.Currency == "" {
+		product.Price.Currency = s.pricing.Base
+	}
+	if product.Price.Currency != s.pricing.Base {
+		return &types.ValidationError{Field: "price", Reason: "not_base_currency"}
+	}
+	if product.Price.Amount <= 0 {
+		return &types.ValidationError{Field: "price", Reason: "not_positive"}
+	}
+
+	seen := make(map[string]bool, len(product.Prices))
+	for _, price := range product.Prices {
+		supported := false
+		for _, currency := range s.pricing.Currencies {
+			supported = supported || currency == price.Currency
+		}
+		if !supported || price.Currency == s.pricing.Base {
+			return &types.ValidationError{Field: "prices", Reason: "not_supported"}
+		}
+		if seen[price.Currency] {
+			return &types.ValidationError{Field: "prices", Reason: "duplicate"}
+		}
+		if price.Amount <= 0 {
+			return &types.ValidationError{Field: "prices", Reason: "not_positive"}
+		}
+		seen[price.Currency] = true
+	}
+	return nil
+}
+
+// savePrices - replaces own prices of the product in other currencies.
+func savePrices(ctx context.Context, tx pgx.Tx, product *types.Products) error {
+	currencies := make([]string, len(product.Prices))
+	amounts := make([]int64, len(product.Prices))
+	for i, price := range product.Prices {
+		currencies[i] = price.Currency
+		amounts[i] = price.Amount
+	}
+
+	_, err := tx.Exec(ctx, `DELETE FROM product_prices WHERE product_id = $1 AND NOT currency = ANY ($2);`, product.ID, currencies)
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+
+	sql := `INSERT INTO product_prices (product_id, currency, price)
+			SELECT $1, currency, price FROM unnest ($2::TEXT[], $3::BIGINT[]) AS p (currency, price)
+			ON CONFLICT (product_id, currency) DO UPDATE SET price = excluded.price;`
+	_, err = tx.Exec(ctx, sql, product.ID, currencies, amounts)
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+	return nil
+}
+
+// RemoveProductByID - archives the product with the version: it isn't sold and found any more,
+// but sales keep it.
+func (s *Service) RemoveProductByID(ctx context.Context, id int64, version int64) (*types.Products, error) {
+	item := &types.Products{}
+
+	sql := `UPDATE products SET deleted_at = CURRENT_TIMESTAMP, version = version + 1
+			WHERE id = $1 AND deleted_at IS NULL AND ($2 = 0 OR version = $2)
+			RETURNING ` + productColumns + `;`
+	err := scanProduct(s.pool.QueryRow(ctx, sql, id, version), item)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, unchangedError(ctx, s.pool, "products", "deleted_at IS NULL", id, version)
+	}
 	if err != nil {
 		log.Println(err)
 		return nil, ErrInternal
 	}
+	item.Price.Currency = s.pricing.Base
 	return item, nil
 }
 
-// GetCustomer -  shows information about customers.
-func (s *Service) GetCustomer(ctx context.Context) ([]*types.Customers, error) {
+// GetCustomer -  shows information about customers, archived customers are shown only if archived is true,
+// only active or inactive ones if active isn't nil.
+func (s *Service) GetCustomer(ctx context.Context, archived bool, active *bool) ([]*types.Customers, error) {
 	items := make([]*types.Customers, 0)
-	sql := `SELECT id, name, phone, active, created FROM customers 
-			WHERE active = true ORDER BY id LIMIT 500;`
+	sql := `SELECT id, name, phone, active, version, deleted_at, created FROM customers 
+			WHERE ($1 OR deleted_at IS NULL) AND ($2::BOOLEAN IS NULL OR active = $2) ORDER BY id LIMIT 500;`
 
-	rows, err := s.pool.Query(ctx, sql)
+	rows, err := s.pool.Query(ctx, sql, archived, active)
 
 	if err != nil {
 		if err == pgx.ErrNoRows {
@@ -321,6 +910,8 @@ func (s *Service) GetCustomer(ctx context.Context) ([]*types.Customers, error) {
 			&item.Name,
 			&item.Phone,
 			&item.Active,
+			&item.Version,
+			&item.DeletedAt,
 			&item.Created)
 
 		if err != nil {
@@ -333,39 +924,257 @@ func (s *Service) GetCustomer(ctx context.Context) ([]*types.Customers, error) {
 	return items, nil
 }
 
-// ChangeCustomer - change information about customers.
-func (s *Service) ChangeCustomer(ctx context.Context, customer *types.Customers) (*types.Customers, error) {
+// CustomerByID - returns information about the customer, archived customers are shown too.
+func (s *Service) CustomerByID(ctx context.Context, id int64) (*types.Customers, error) {
+	item := &types.Customers{}
+	sql := `SELECT id, name, phone, active, version, deleted_at, created FROM customers WHERE id = $1;`
+	err := s.pool.QueryRow(ctx, sql, id).Scan(
+		&item.ID,
+		&item.Name,
+		&item.Phone,
+		&item.Active,
+		&item.Version,
+		&item.DeletedAt,
+		&item.Created)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+	return item, nil
+}
+
+// CreateCustomer - saves the new customer without password, the customer sets it by password reset.
+func (s *Service) CreateCustomer(ctx context.Context, customer *types.Customers) (*types.Customers, error) {
+	customer.ID = 0
+	return s.storeCustomer(ctx, customer, AnyVersion, nil)
+}
+
+// ReplaceCustomer - replaces all information about the customer which isn't archived and has the version.
+func (s *Service) ReplaceCustomer(ctx context.Context, customer *types.Customers, version int64) (*types.Customers, error) {
+	return s.storeCustomer(ctx, customer, version, nil)
+}
+
+// PatchCustomer - changes only the fields of customer with the version given in JSON Merge Patch.
+func (s *Service) PatchCustomer(ctx context.Context, id int64, version int64, changes []byte) (*types.Customers, error) {
+	return s.storeCustomer(ctx, &types.Customers{ID: id}, version, changes)
+}
+
+// storeCustomer - inserts the customer if its id is 0, otherwise updates it if it has the version.
+// If changes are given, they are applied to the saved customer. The saved customer is locked
+// until the end of transaction, so it can't be changed by other request meanwhile.
+func (s *Service) storeCustomer(ctx context.Context, customer *types.Customers, version int64, changes []byte) (*types.Customers, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	if customer.ID != 0 {
+		item := &types.Customers{}
+		sql := `SELECT id, name, phone, active, version, deleted_at, created FROM customers
+				WHERE id = $1 AND deleted_at IS NULL FOR UPDATE;`
+		err = tx.QueryRow(ctx, sql, customer.ID).Scan(
+			&item.ID,
+			&item.Name,
+			&item.Phone,
+			&item.Active,
+			&item.Version,
+			&item.DeletedAt,
+			&item.Created)
+
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		if err != nil {
+			log.Println(err)
+			return nil, ErrInternal
+		}
+
+		err = checkVersion(item.Version, version)
+		if err != nil {
+			return nil, err
+		}
 
-	sql := `UPDATE customers SET name = $1, phone = $2, active = $3 WHERE id = $4 
-			RETURNING name, phone, active;`
-	err := s.pool.QueryRow(ctx, sql, customer.Name, customer.Phone, customer.Active, customer.ID).Scan(
-		&customer.Name,
-		&customer.Phone,
-		&customer.Active)
+		if changes != nil {
+			customer = &types.Customers{}
+			err = applyPatch(item, changes, customer)
+			if err != nil {
+				return nil, err
+			}
+			customer.ID = item.ID
+		}
+	}
+
+	customer.Name = strings.TrimSpace(customer.Name)
+	if customer.Name == "" {
+		return nil, &types.ValidationError{Field: "name", Reason: "empty"}
+	}
+	customer.Phone = strings.TrimSpace(customer.Phone)
+	if customer.Phone == "" {
+		return nil, &types.ValidationError{Field: "phone", Reason: "empty"}
+	}
+
+	if customer.ID == 0 {
+		// empty password never matches, so the customer can't sign in until the password is set.
+		sql1 := `INSERT INTO customers (name, phone, password, active) VALUES ($1, $2, '', $3)
+				 RETURNING id, name, phone, active, version, deleted_at, created;`
+		err = tx.QueryRow(ctx, sql1, customer.Name, customer.Phone, customer.Active).Scan(
+			&customer.ID,
+			&customer.Name,
+			&customer.Phone,
+			&customer.Active,
+			&customer.Version,
+			&customer.DeletedAt,
+			&customer.Created)
 
+	} else {
+		sql2 := `UPDATE customers SET name = $1, phone = $2, active = $3, version = version + 1 WHERE id = $4
+				 RETURNING id, name, phone, active, version, deleted_at, created;`
+		err = tx.QueryRow(ctx, sql2, customer.Name, customer.Phone, customer.Active, customer.ID).Scan(
+			&customer.ID,
+			&customer.Name,
+			&customer.Phone,
+			&customer.Active,
+			&customer.Version,
+			&customer.DeletedAt,
+			&customer.Created)
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+		return nil, ErrPhoneUsed
+	}
 	if err != nil {
 		log.Println(err)
 		return nil, ErrInternal
 	}
 
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
 	return customer, nil
 }
 
-// RemoveCustomerByID - remove information about the ucustomers ID.
-func (s *Service) RemoveCustomerByID(ctx context.Context, id int64) (*types.Customers, error) {
+// RemoveCustomerByID - archives the customer with the version: sessions are closed and the customer
+// can't sign in, but sales keep the customer.
+func (s *Service) RemoveCustomerByID(ctx context.Context, id int64, version int64) (*types.Customers, error) {
 	item := &types.Customers{}
 
-	sql := `DELETE FROM customers WHERE id = $1 RETURNING id, name, phone, active, created;`
-	err := s.pool.QueryRow(ctx, sql, id).Scan(
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	sql := `UPDATE customers SET deleted_at = CURRENT_TIMESTAMP, version = version + 1
+			WHERE id = $1 AND deleted_at IS NULL AND ($2 = 0 OR version = $2)
+			RETURNING id, name, phone, active, version, deleted_at, created;`
+	err = tx.QueryRow(ctx, sql, id, version).Scan(
 		&item.ID,
 		&item.Name,
 		&item.Phone,
 		&item.Active,
+		&item.Version,
+		&item.DeletedAt,
 		&item.Created)
 
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, unchangedError(ctx, tx, "customers", "deleted_at IS NULL", id, version)
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+
+	revoked, err := deleteCustomerTokens(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit(ctx)
 	if err != nil {
 		log.Println(err)
 		return nil, ErrInternal
 	}
+
+	err = s.revokeSigned(ctx, revoked)
+	if err != nil {
+		return nil, err
+	}
 	return item, nil
 }
+
+// deleteCustomerTokens - deletes the tokens of customer in the transaction and returns them,
+// so signed ones can be revoked after commit.
+func deleteCustomerTokens(ctx context.Context, tx pgx.Tx, id int64) ([]string, error) {
+	revoked := make([]string, 0)
+	rows, err := tx.Query(ctx, `DELETE FROM customers_tokens WHERE customer_id = $1 RETURNING token;`, id)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var token string
+		if err = rows.Scan(&token); err != nil {
+			log.Println(err)
+			return nil, ErrInternal
+		}
+		revoked = append(revoked, token)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+	return revoked, nil
+}
+
+// UnlockCustomer - removes the lock of customer logins.
+func (s *Service) UnlockCustomer(ctx context.Context, id int64) error {
+
+	var phone string
+	sql := `SELECT phone FROM customers WHERE id = $1;`
+	err := s.pool.QueryRow(ctx, sql, id).Scan(&phone)
+	if err == pgx.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+
+	return s.logins.Unlock(ctx, logins.KindCustomer, phone)
+}
+
+// UnlockManager - removes the lock of manager logins.
+func (s *Service) UnlockManager(ctx context.Context, id int64) error {
+
+	var phone string
+	sql := `SELECT phone FROM managers WHERE id = $1;`
+	err := s.pool.QueryRow(ctx, sql, id).Scan(&phone)
+	if err == pgx.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+
+	return s.logins.Unlock(ctx, logins.KindManager, phone)
+}
+
+// LoginHistory - shows the login attempts of customers and managers.
+func (s *Service) LoginHistory(ctx context.Context, filter *types.LoginFilter) ([]*types.Login, error) {
+	return s.logins.History(ctx, filter)
+}
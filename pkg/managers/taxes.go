@@ -0,0 +1,100 @@
+package managers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+
+	"github.com/SardorMS/CRUD/pkg/types"
+)
+
+// TaxClasses - shows the tax classes of products.
+func (s *Service) TaxClasses(ctx context.Context) ([]*types.TaxClass, error) {
+
+	items := make([]*types.TaxClass, 0)
+	sql := `SELECT id, name, rate, inclusive, created FROM tax_classes ORDER BY name;`
+	rows, err := s.pool.Query(ctx, sql)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := &types.TaxClass{}
+		err = rows.Scan(&item.ID, &item.Name, &item.Rate, &item.Inclusive, &item.Created)
+		if err != nil {
+			log.Println(err)
+			return nil, ErrInternal
+		}
+		items = append(items, item)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+
+	return items, nil
+}
+
+// CreateTaxClass - creates the tax class with unique name.
+func (s *Service) CreateTaxClass(ctx context.Context, item *types.TaxClass) (*types.TaxClass, error) {
+	err := validateTaxClass(item)
+	if err != nil {
+		return nil, err
+	}
+
+	sql := `INSERT INTO tax_classes (name, rate, inclusive) VALUES ($1, $2, $3)
+			ON CONFLICT (name) DO NOTHING RETURNING id, created;`
+	err = s.pool.QueryRow(ctx, sql, item.Name, item.Rate, item.Inclusive).Scan(&item.ID, &item.Created)
+	if err == pgx.ErrNoRows {
+		return nil, ErrTaxClassExists
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+	return item, nil
+}
+
+// ChangeTaxClass - changes the name and the rate of tax class, positions of made sales
+// keep the tax they were sold with.
+func (s *Service) ChangeTaxClass(ctx context.Context, item *types.TaxClass) (*types.TaxClass, error) {
+	err := validateTaxClass(item)
+	if err != nil {
+		return nil, err
+	}
+
+	sql := `UPDATE tax_classes SET name = $2, rate = $3, inclusive = $4 WHERE id = $1 RETURNING created;`
+	err = s.pool.QueryRow(ctx, sql, item.ID, item.Name, item.Rate, item.Inclusive).Scan(&item.Created)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+		return nil, ErrTaxClassExists
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+	return item, nil
+}
+
+// validateTaxClass - checks the name and the rate (up to 100%) of tax class.
+func validateTaxClass(item *types.TaxClass) error {
+	item.Name = strings.TrimSpace(item.Name)
+	if item.Name == "" {
+		return &types.ValidationError{Field: "name", Reason: "empty"}
+	}
+	if item.Rate < 0 || item.Rate > 10000 {
+		return &types.ValidationError{Field: "rate", Reason: "out_of_range"}
+	}
+	return nil
+}
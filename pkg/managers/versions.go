@@ -0,0 +1,43 @@
+package managers
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// AnyVersion - expected version when the record is changed whatever its version is,
+// versions of records start from 1.
+const AnyVersion int64 = 0
+
+// queryRower - pool or transaction.
+type queryRower interface {
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// checkVersion - checks that the version of record is expected.
+func checkVersion(version int64, expected int64) error {
+	if expected != AnyVersion && version != expected {
+		return ErrVersionMismatch
+	}
+	return nil
+}
+
+// unchangedError - tells why the record of the table which must match the condition wasn't
+// changed: it isn't found or has other version.
+func unchangedError(ctx context.Context, db queryRower, table string, condition string, id int64, expected int64) error {
+	var version int64
+	err := db.QueryRow(ctx, `SELECT version FROM `+table+` WHERE id = $1 AND `+condition+`;`, id).Scan(&version)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+
+	// the record is found, so its version isn't expected.
+	return ErrVersionMismatch
+}
@@ -0,0 +1,38 @@
+package passwords
+
+import (
+	"strings"
+
+	"github.com/SardorMS/CRUD/pkg/types"
+)
+
+// Policy - requirements to passwords of customers and managers.
+type Policy struct {
+	MinLength int
+}
+
+// Validate - checks the password against the policy, phone is the login of user.
+func (p Policy) Validate(password string, phone string) error {
+
+	if len([]rune(password)) < p.MinLength {
+		return &types.ValidationError{Field: "password", Reason: "too_short"}
+	}
+
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, phone)
+
+	if password == phone || (digits != "" && strings.Contains(password, digits)) {
+		return &types.ValidationError{Field: "password", Reason: "phone_as_password"}
+	}
+
+	// local part of the phone number, without country code.
+	if len(digits) > 9 && strings.Contains(password, digits[len(digits)-9:]) {
+		return &types.ValidationError{Field: "password", Reason: "phone_as_password"}
+	}
+
+	return nil
+}
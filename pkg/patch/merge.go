@@ -0,0 +1,55 @@
+package patch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+)
+
+// ErrInvalidPatch - returned when the patch isn't JSON object.
+var ErrInvalidPatch = errors.New("invalid merge patch")
+
+// Merge - applies JSON Merge Patch (RFC 7386) to the document: fields of patch replace
+// the fields of document, objects are merged recursively, null removes the field.
+func Merge(document []byte, patch []byte) ([]byte, error) {
+	var target, changes interface{}
+	if err := decode(document, &target); err != nil {
+		return nil, err
+	}
+	if err := decode(patch, &changes); err != nil {
+		return nil, ErrInvalidPatch
+	}
+	if _, ok := changes.(map[string]interface{}); !ok {
+		return nil, ErrInvalidPatch
+	}
+
+	return json.Marshal(merge(target, changes))
+}
+
+// merge - returns the target with the changes.
+func merge(target interface{}, changes interface{}) interface{} {
+	fields, ok := changes.(map[string]interface{})
+	if !ok {
+		return changes
+	}
+
+	object, ok := target.(map[string]interface{})
+	if !ok {
+		object = make(map[string]interface{}, len(fields))
+	}
+	for name, value := range fields {
+		if value == nil {
+			delete(object, name)
+			continue
+		}
+		object[name] = merge(object[name], value)
+	}
+	return object
+}
+
+// decode - reads JSON keeping numbers as they are, so big integers don't lose precision.
+func decode(data []byte, value interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(value)
+}
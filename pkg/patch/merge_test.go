@@ -0,0 +1,86 @@
+package patch
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	// examples from Appendix A of RFC 7386 with object documents, which are the only ones patched here.
+	tests := []struct {
+		name     string
+		document string
+		patch    string
+		want     string
+	}{
+		{"replace field", `{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{"add field", `{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{"null deletes field", `{"a":"b"}`, `{"a":null}`, `{}`},
+		{"null deletes one of fields", `{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{"null deletes missing field", `{"a":"b"}`, `{"c":null}`, `{"a":"b"}`},
+		{"value replaces array", `{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{"array replaces value", `{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{"nested merge", `{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{"array of objects replaced", `{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{"null of document kept", `{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+		{"nested object into value", `{"a":"foo"}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+		{"empty patch", `{"a":"b"}`, `{}`, `{"a":"b"}`},
+		{
+			"complex",
+			`{"title":"Goodbye!","author":{"givenName":"John","familyName":"Doe"},"tags":["example","sample"],"content":"This will be unchanged"}`,
+			`{"title":"Hello!","phoneNumber":"+01-123-456-7890","author":{"familyName":null},"tags":["example"]}`,
+			`{"title":"Hello!","author":{"givenName":"John"},"tags":["example"],"content":"This will be unchanged","phoneNumber":"+01-123-456-7890"}`,
+		},
+		{"big integer kept", `{"id":9007199254740993}`, `{"price":12345678901234567}`, `{"id":9007199254740993,"price":12345678901234567}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Merge([]byte(test.document), []byte(test.patch))
+			if err != nil {
+				t.Fatalf("Merge(%s, %s) error = %v", test.document, test.patch, err)
+			}
+			if !equalJSON(t, got, []byte(test.want)) {
+				t.Errorf("Merge(%s, %s) = %s, want %s", test.document, test.patch, got, test.want)
+			}
+		})
+	}
+}
+
+func TestMergeInvalidPatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch string
+	}{
+		{"null", `null`},
+		{"array", `["a"]`},
+		{"string", `"a"`},
+		{"number", `1`},
+		{"malformed", `{"a":`},
+		{"empty", ``},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Merge([]byte(`{"a":"b"}`), []byte(test.patch))
+			if !errors.Is(err, ErrInvalidPatch) {
+				t.Errorf("Merge with patch %s error = %v, want %v", test.patch, err, ErrInvalidPatch)
+			}
+		})
+	}
+}
+
+// equalJSON - compares JSON documents ignoring the order of fields.
+func equalJSON(t *testing.T, a []byte, b []byte) bool {
+	t.Helper()
+
+	var left, right interface{}
+	if err := decode(a, &left); err != nil {
+		t.Fatalf("decode %s: %v", a, err)
+	}
+	if err := decode(b, &right); err != nil {
+		t.Fatalf("decode %s: %v", b, err)
+	}
+	return reflect.DeepEqual(left, right)
+}
@@ -0,0 +1,231 @@
+package receipts
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+
+	"github.com/SardorMS/CRUD/pkg/config"
+	"github.com/SardorMS/CRUD/pkg/managers"
+	"github.com/SardorMS/CRUD/pkg/types"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+var (
+	ErrInternal      = errors.New("internal error")         // return when an internal error occurred.
+	ErrNotFound      = errors.New("receipt not found")      // return when sale isn't found or isn't available.
+	ErrUnknownFormat = errors.New("unknown receipt format") // return when format isn't supported.
+)
+
+// Formats of receipts.
+const (
+	FormatText = "txt"
+	FormatHTML = "html"
+	FormatPDF  = "pdf"
+)
+
+// Built-in templates, used when the directory of templates isn't configured
+// or has no template of the format.
+//
+//go:embed templates
+var builtin embed.FS
+
+// funcs - functions available in templates.
+var funcs = map[string]interface{}{
+	"percent": percent,
+	"amount":  amount,
+}
+
+// percent - formats the rate in basis points as percents, e.g. 1250 as 12.5.
+func percent(rate int) string {
+	text := fmt.Sprintf("%d.%02d", rate/100, rate%100)
+	return strings.TrimSuffix(strings.TrimRight(text, "0"), ".")
+}
+
+// amount - formats the money in major units without the currency, e.g. 1250.50.
+func amount(money types.Money) string {
+	return strings.TrimSuffix(money.String(), " "+money.Currency)
+}
+
+// Receipt - data printed on the receipt of sale.
+type Receipt struct {
+	Store    config.Store
+	Sale     *types.Sale
+	Manager  string // empty when customer checked out the cart.
+	Customer string
+	Subtotal types.Money // sum of positions before discounts.
+	Discount types.Money
+	Tax      types.Money // included in the prices and added to them.
+	Total    types.Money
+	Paid     types.Money
+	Due      types.Money // left to pay.
+}
+
+// Service - describes receipts service.
+type Service struct {
+	pool  *pgxpool.Pool
+	sales *managers.Service
+	store config.Store
+	dir   string
+}
+
+// NewService - create a service.
+func NewService(pool *pgxpool.Pool, managersSvc *managers.Service, cfg *config.Config) *Service {
+	return &Service{
+		pool:  pool,
+		sales: managersSvc,
+		store: cfg.Store,
+		dir:   cfg.ReceiptTemplates,
+	}
+}
+
+// ContentType - returns the content type of receipt in the format.
+func ContentType(format string) string {
+	switch format {
+	case FormatHTML:
+		return "text/html; charset=utf-8"
+	case FormatPDF:
+		return "application/pdf"
+	default:
+		return "text/plain; charset=utf-8"
+	}
+}
+
+// Receipt - returns the receipt of sale. If managerID isn't 0 only the sale made by
+// this manager is found, if customerID isn't 0 only the sale of this customer.
+func (s *Service) Receipt(ctx context.Context, id int64, managerID int64, customerID int64) (*Receipt, error) {
+	sale, err := s.sales.SaleByID(ctx, id, managerID)
+	if errors.Is(err, managers.ErrNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, ErrInternal
+	}
+
+	if customerID != 0 && sale.CustomerID != customerID {
+		return nil, ErrNotFound
+	}
+
+	item := &Receipt{
+		Store:    s.store,
+		Sale:     sale,
+		Subtotal: types.NewMoney(0, sale.Currency),
+		Discount: types.NewMoney(0, sale.Currency),
+		Tax:      sale.Tax,
+		Total:    sale.Total,
+		Paid:     sale.Paid,
+		Due:      types.NewMoney(0, sale.Currency),
+	}
+
+	sql := `SELECT COALESCE ((SELECT name FROM managers WHERE id = $1), ''),
+				   COALESCE ((SELECT name FROM customers WHERE id = $2), '');`
+	err = s.pool.QueryRow(ctx, sql, sale.ManagerID, sale.CustomerID).Scan(&item.Manager, &item.Customer)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+
+	for _, position := range sale.Positions {
+		item.Subtotal = item.Subtotal.Add(position.Price.Mul(int64(position.Qty)))
+		item.Discount = item.Discount.Add(position.Discount)
+	}
+	if due := item.Total.Sub(item.Paid); due.Amount > 0 {
+		item.Due = due
+	}
+
+	return item, nil
+}
+
+// Render - writes the receipt in the format. PDF is made from the text template.
+func (s *Service) Render(writer io.Writer, format string, receipt *Receipt) error {
+	switch format {
+	case FormatText:
+		return s.renderText(writer, receipt)
+	case FormatHTML:
+		text, err := s.template("receipt.html.tmpl")
+		if err != nil {
+			return err
+		}
+
+		tmpl, err := htmltemplate.New("receipt").Funcs(htmltemplate.FuncMap(funcs)).Parse(text)
+		if err != nil {
+			log.Println(err)
+			return ErrInternal
+		}
+		return execute(writer, tmpl, receipt)
+	case FormatPDF:
+		var buf bytes.Buffer
+		err := s.renderText(&buf, receipt)
+		if err != nil {
+			return err
+		}
+		return writePDF(writer, strings.Split(strings.TrimRight(buf.String(), "\n"), "\n"))
+	default:
+		return ErrUnknownFormat
+	}
+}
+
+// renderText - writes the receipt by text template.
+func (s *Service) renderText(writer io.Writer, receipt *Receipt) error {
+	text, err := s.template("receipt.txt.tmpl")
+	if err != nil {
+		return err
+	}
+
+	tmpl, err := texttemplate.New("receipt").Funcs(texttemplate.FuncMap(funcs)).Parse(text)
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+	return execute(writer, tmpl, receipt)
+}
+
+// template - reads the template by name from the directory of templates, so they can
+// be changed without restart, or the built-in one.
+func (s *Service) template(name string) (string, error) {
+	if s.dir != "" {
+		data, err := os.ReadFile(filepath.Join(s.dir, name))
+		if err == nil {
+			return string(data), nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Println(err)
+			return "", ErrInternal
+		}
+	}
+
+	data, err := builtin.ReadFile("templates/" + name)
+	if err != nil {
+		log.Println(err)
+		return "", ErrInternal
+	}
+	return string(data), nil
+}
+
+// executor - template of text or html.
+type executor interface {
+	Execute(writer io.Writer, data interface{}) error
+}
+
+// execute - renders the template to buffer first, so the broken template doesn't
+// leave half written response.
+func execute(writer io.Writer, tmpl executor, receipt *Receipt) error {
+	var buf bytes.Buffer
+	err := tmpl.Execute(&buf, receipt)
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+
+	_, err = buf.WriteTo(writer)
+	return err
+}
@@ -0,0 +1,140 @@
+package sales
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/big"
+
+	"github.com/jackc/pgx/v4"
+
+	"github.com/SardorMS/CRUD/pkg/types"
+)
+
+// Pricing - currencies of sales. Prices of products are in the base currency, in multi-currency
+// mode sales can be made in other currencies by own prices of products or by exchange rates.
+type Pricing struct {
+	Base       string
+	Currencies []string
+}
+
+// supports - checks that sales can be made in the currency.
+func (p Pricing) supports(currency string) bool {
+	if currency == p.Base {
+		return true
+	}
+	for _, item := range p.Currencies {
+		if item == currency {
+			return true
+		}
+	}
+	return false
+}
+
+// exchange - currency of the sale with its rate: minor units of the base currency
+// for one minor unit of the currency.
+type exchange struct {
+	base     bool
+	currency string
+	rate     *big.Rat
+}
+
+// exchangeOf - reads the exchange rate of the currency, in which sales must be supported.
+func exchangeOf(ctx context.Context, tx pgx.Tx, pricing Pricing, currency string) (*exchange, error) {
+	if currency == pricing.Base {
+		return &exchange{base: true, currency: currency, rate: big.NewRat(1, 1)}, nil
+	}
+	if !pricing.supports(currency) {
+		return nil, &types.ValidationError{Field: "currency", Reason: "not_supported"}
+	}
+
+	var text string
+	err := tx.QueryRow(ctx, `SELECT rate::TEXT FROM exchange_rates WHERE currency = $1;`, currency).Scan(&text)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, &types.ValidationError{Field: "currency", Reason: "no_rate"}
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+
+	rate, ok := new(big.Rat).SetString(text)
+	if !ok {
+		log.Printf("invalid exchange rate of %s: %s", currency, text)
+		return nil, ErrInternal
+	}
+
+	return newExchange(pricing.Base, currency, rate), nil
+}
+
+// newExchange - exchange of the currency by the rate given for major units,
+// e.g. 1 USD = 12650 UZS, so 1 cent = 12650 tiyin.
+func newExchange(base, currency string, rate *big.Rat) *exchange {
+	rate = new(big.Rat).Mul(rate, pow10(types.MinorUnits(base)))
+	rate.Quo(rate, pow10(types.MinorUnits(currency)))
+	return &exchange{currency: currency, rate: rate}
+}
+
+// fromBase - converts the amount in the base currency to the currency, rounded half up.
+func (e *exchange) fromBase(amount int64) int64 {
+	if e.base {
+		return amount
+	}
+	return roundRat(new(big.Rat).Quo(new(big.Rat).SetInt64(amount), e.rate))
+}
+
+// String - rate saved with the sale.
+func (e *exchange) String() string {
+	return e.rate.FloatString(12)
+}
+
+// pow10 - 10 to the power of n.
+func pow10(n int) *big.Rat {
+	return new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil))
+}
+
+// roundRat - rounds non-negative number half up.
+func roundRat(value *big.Rat) int64 {
+	num := new(big.Int).Mul(value.Num(), big.NewInt(2))
+	num.Add(num, value.Denom())
+	return num.Quo(num, new(big.Int).Mul(value.Denom(), big.NewInt(2))).Int64()
+}
+
+// localPrices - sets prices of the products in the currency of sale: own prices of products
+// or prices in the base currency converted by exchange rate.
+func localPrices(ctx context.Context, tx pgx.Tx, products map[int64]*product, exchange *exchange) error {
+	if exchange.base {
+		return nil
+	}
+
+	ids := make([]int64, 0, len(products))
+	for id, item := range products {
+		ids = append(ids, id)
+		item.price = exchange.fromBase(item.price)
+	}
+
+	sql := `SELECT product_id, price FROM product_prices WHERE product_id = ANY($1) AND currency = $2;`
+	rows, err := tx.Query(ctx, sql, ids, exchange.currency)
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, price int64
+		err = rows.Scan(&id, &price)
+		if err != nil {
+			log.Println(err)
+			return ErrInternal
+		}
+		products[id].price = price
+	}
+
+	err = rows.Err()
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+	return nil
+}
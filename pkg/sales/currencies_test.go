@@ -0,0 +1,68 @@
+package sales
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestRoundRat(t *testing.T) {
+	tests := []struct {
+		name  string
+		value *big.Rat
+		want  int64
+	}{
+		{"zero", big.NewRat(0, 1), 0},
+		{"integer", big.NewRat(5, 1), 5},
+		{"below half", big.NewRat(1, 3), 0},
+		{"above half", big.NewRat(2, 3), 1},
+		{"half", big.NewRat(1, 2), 1},
+		{"one and half", big.NewRat(3, 2), 2},
+		{"just below half", big.NewRat(499999, 1000000), 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := roundRat(test.value); got != test.want {
+				t.Errorf("roundRat(%s) = %d, want %d", test.value, got, test.want)
+			}
+		})
+	}
+}
+
+func TestExchangeFromBase(t *testing.T) {
+	tests := []struct {
+		name     string
+		base     string
+		currency string
+		rate     *big.Rat
+		amount   int64
+		want     int64
+	}{
+		{"same minor units", "UZS", "USD", big.NewRat(12650, 1), 126500, 10},
+		{"half cent rounded up", "UZS", "USD", big.NewRat(12650, 1), 6325, 1},
+		{"below half cent", "UZS", "USD", big.NewRat(12650, 1), 6324, 0},
+		{"fractional rate", "UZS", "USD", big.NewRat(126505, 10), 1265050000, 100000},
+		{"zero decimal currency", "UZS", "JPY", big.NewRat(85, 1), 1000000, 118},
+		{"zero decimal currency half", "UZS", "JPY", big.NewRat(85, 1), 4250, 1},
+		{"zero decimal currency below half", "UZS", "JPY", big.NewRat(85, 1), 4249, 0},
+		{"zero decimal base", "JPY", "USD", big.NewRat(150, 1), 150, 100},
+		{"zero decimal base rounded", "JPY", "USD", big.NewRat(150, 1), 1, 1},
+		{"three decimal currency", "UZS", "KWD", big.NewRat(41000, 1), 8200, 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			exchange := newExchange(test.base, test.currency, test.rate)
+			if got := exchange.fromBase(test.amount); got != test.want {
+				t.Errorf("fromBase(%d) %s to %s by %s = %d, want %d", test.amount, test.base, test.currency, test.rate, got, test.want)
+			}
+		})
+	}
+}
+
+func TestExchangeFromBaseCurrency(t *testing.T) {
+	exchange := &exchange{base: true, currency: "UZS", rate: big.NewRat(1, 1)}
+	if got := exchange.fromBase(12345); got != 12345 {
+		t.Errorf("fromBase(12345) = %d, want 12345", got)
+	}
+}
@@ -0,0 +1,290 @@
+package sales
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/jackc/pgx/v4"
+
+	"github.com/SardorMS/CRUD/pkg/types"
+)
+
+// Statuses of sales.
+const (
+	StatusPending           = "pending"            // made, not paid yet.
+	StatusPaid              = "paid"               // paid in full.
+	StatusPartiallyRefunded = "partially_refunded" // some products are returned.
+	StatusRefunded          = "refunded"           // all products are returned.
+	StatusVoided            = "voided"             // canceled, products are back in stock.
+)
+
+// Methods of payments.
+const (
+	MethodCash         = "cash"
+	MethodCard         = "card"
+	MethodBankTransfer = "bank_transfer"
+	MethodStoreCredit  = "store_credit"
+)
+
+var (
+	ErrNotFound      = errors.New("sale not found")         // return when sale doesn't exist.
+	ErrInvalidStatus = errors.New("invalid status of sale") // return when sale can't be changed in its status.
+)
+
+// Lock - locks the sale and returns its status, manager, customer, currency, total and paid amount.
+func Lock(ctx context.Context, tx pgx.Tx, id int64) (*types.Sale, error) {
+	sale := &types.Sale{ID: id}
+
+	sql := `SELECT s.status, COALESCE (s.manager_id, 0), s.customer_id, s.currency,
+				   COALESCE ((SELECT SUM (sp.price * sp.qty - sp.discount + CASE WHEN sp.tax_inclusive THEN 0 ELSE sp.tax END)
+							FROM sale_positions sp WHERE sp.sale_id = s.id), 0)::BIGINT,
+				   COALESCE ((SELECT SUM (p.amount) FROM payments p WHERE p.sale_id = s.id), 0)::BIGINT
+			FROM sales s WHERE s.id = $1 FOR UPDATE OF s;`
+	err := tx.QueryRow(ctx, sql, id).Scan(&sale.Status, &sale.ManagerID, &sale.CustomerID, &sale.Currency, &sale.Total.Amount, &sale.Paid.Amount)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+
+	sale.Total.Currency = sale.Currency
+	sale.Paid.Currency = sale.Currency
+	return sale, nil
+}
+
+// Pay - records the payments of pending sale in the transaction, the sale becomes paid
+// when the total is paid. Cash can exceed the rest of total, the difference is the change,
+// payments by other methods can't. Store credit of the customer is decreased.
+func Pay(ctx context.Context, tx pgx.Tx, id int64, payments []*types.Payment, managerID int64) (*types.Sale, error) {
+	if len(payments) == 0 {
+		return nil, &types.ValidationError{Field: "payments", Reason: "empty"}
+	}
+
+	sale, err := Lock(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if sale.Status != StatusPending {
+		return nil, ErrInvalidStatus
+	}
+
+	for _, payment := range payments {
+		due := sale.Total.Sub(sale.Paid)
+		if due.Amount <= 0 {
+			return nil, &types.ValidationError{Field: "payments", Reason: "exceeds_total"}
+		}
+		if payment.Amount.Amount <= 0 {
+			return nil, &types.ValidationError{Field: "amount", Reason: "not_positive"}
+		}
+		if payment.Amount.Currency != "" && payment.Amount.Currency != sale.Currency {
+			return nil, &types.ValidationError{Field: "currency", Reason: "mismatch"}
+		}
+
+		payment.SaleID = id
+		payment.ManagerID = managerID
+		payment.Amount.Currency = sale.Currency
+		payment.Tendered = payment.Amount
+		payment.Change = types.NewMoney(0, sale.Currency)
+
+		switch payment.Method {
+		case MethodCash:
+			if payment.Amount.Amount > due.Amount {
+				payment.Change = payment.Amount.Sub(due)
+				payment.Amount = due
+			}
+		case MethodCard, MethodBankTransfer, MethodStoreCredit:
+			if payment.Amount.Amount > due.Amount {
+				return nil, &types.ValidationError{Field: "amount", Reason: "exceeds_total"}
+			}
+		default:
+			return nil, &types.ValidationError{Field: "method", Reason: "unknown"}
+		}
+
+		if payment.Method == MethodStoreCredit {
+			err = useCredit(ctx, tx, sale, payment.Amount.Amount)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		sql := `INSERT INTO payments (sale_id, method, amount, tendered, change, manager_id)
+				VALUES ($1, $2, $3, $4, $5, NULLIF ($6::BIGINT, 0)) RETURNING id, created;`
+		err = tx.QueryRow(ctx, sql, id, payment.Method, payment.Amount.Amount, payment.Tendered.Amount, payment.Change.Amount, managerID).Scan(
+			&payment.ID,
+			&payment.Created)
+		if err != nil {
+			log.Println(err)
+			return nil, ErrInternal
+		}
+
+		sale.Paid = sale.Paid.Add(payment.Amount)
+	}
+
+	if sale.Paid == sale.Total {
+		err = SetStatus(ctx, tx, id, StatusPaid)
+		if err != nil {
+			return nil, err
+		}
+		sale.Status = StatusPaid
+	}
+
+	sale.Payments = payments
+	return sale, nil
+}
+
+// Void - cancels pending or paid sale in the transaction: the products are put back in stock,
+// the payments are refunded by the manager and the use of promo code is returned.
+func Void(ctx context.Context, tx pgx.Tx, id int64, managerID int64) error {
+	sale, err := Lock(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	if sale.Status != StatusPending && sale.Status != StatusPaid {
+		return ErrInvalidStatus
+	}
+
+	sql := `UPDATE products p SET qty = p.qty + v.qty, version = p.version + 1
+			FROM (SELECT product_id, SUM (qty) qty FROM sale_positions WHERE sale_id = $1 GROUP BY product_id) v
+			WHERE p.id = v.product_id;`
+	_, err = tx.Exec(ctx, sql, id)
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+
+	_, err = Refund(ctx, tx, sale, sale.Paid.Amount, 0, managerID)
+	if err != nil {
+		return err
+	}
+
+	// promotion with code is given to positions only when the code is applied to the sale.
+	sql = `UPDATE promotions SET used = used - 1
+		   WHERE code IS NOT NULL AND used > 0 AND id IN (SELECT promotion_id FROM sale_positions WHERE sale_id = $1);`
+	_, err = tx.Exec(ctx, sql, id)
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+
+	return SetStatus(ctx, tx, id, StatusVoided)
+}
+
+// Refund - gives back the amount of the locked sale in the transaction by the methods it was paid,
+// the latest payments first, each refund is linked to its payment. Store credit is given back
+// by the exchange rate of the sale. If returnID isn't 0 the refund is made for the return.
+func Refund(ctx context.Context, tx pgx.Tx, sale *types.Sale, amount int64, returnID int64, managerID int64) ([]*types.Refund, error) {
+	sql := `SELECT p.id, p.method, p.amount - COALESCE ((SELECT SUM (r.amount) FROM refunds r WHERE r.payment_id = p.id), 0)
+			FROM payments p WHERE p.sale_id = $1 ORDER BY p.id DESC;`
+	rows, err := tx.Query(ctx, sql, sale.ID)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+
+	// the rest of each payment which isn't refunded yet.
+	refunds := make([]*types.Refund, 0)
+	for rows.Next() {
+		item := &types.Refund{SaleID: sale.ID, ReturnID: returnID, ManagerID: managerID}
+		err = rows.Scan(&item.PaymentID, &item.Method, &item.Amount.Amount)
+		if err != nil {
+			rows.Close()
+			log.Println(err)
+			return nil, ErrInternal
+		}
+		item.Amount.Currency = sale.Currency
+		refunds = append(refunds, item)
+	}
+	rows.Close()
+
+	err = rows.Err()
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+
+	items := make([]*types.Refund, 0)
+	for _, item := range refunds {
+		if amount == 0 {
+			break
+		}
+		if item.Amount.Amount <= 0 {
+			continue
+		}
+		if item.Amount.Amount > amount {
+			item.Amount.Amount = amount
+		}
+
+		if item.Method == MethodStoreCredit {
+			err = restoreCredit(ctx, tx, sale, item.Amount.Amount)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		sql = `INSERT INTO refunds (sale_id, payment_id, return_id, method, amount, manager_id)
+			   VALUES ($1, $2, NULLIF ($3::BIGINT, 0), $4, $5, NULLIF ($6::BIGINT, 0)) RETURNING id, created;`
+		err = tx.QueryRow(ctx, sql, sale.ID, item.PaymentID, returnID, item.Method, item.Amount.Amount, managerID).Scan(
+			&item.ID,
+			&item.Created)
+		if err != nil {
+			log.Println(err)
+			return nil, ErrInternal
+		}
+
+		amount -= item.Amount.Amount
+		items = append(items, item)
+	}
+
+	if amount > 0 {
+		log.Printf("refund of sale %d exceeds its payments by %d", sale.ID, amount)
+		return nil, ErrInternal
+	}
+	return items, nil
+}
+
+// SetStatus - changes the status of the sale.
+func SetStatus(ctx context.Context, tx pgx.Tx, id int64, status string) error {
+	_, err := tx.Exec(ctx, `UPDATE sales SET status = $2 WHERE id = $1;`, id, status)
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+	return nil
+}
+
+// restoreCredit - gives back store credit of the customer of the sale, the amount is converted
+// by the exchange rate of the sale.
+func restoreCredit(ctx context.Context, tx pgx.Tx, sale *types.Sale, amount int64) error {
+	sql := `UPDATE customers c SET credit = c.credit + v.amount
+			FROM (SELECT ROUND ($2 * rate) amount FROM sales WHERE id = $3) v
+			WHERE c.id = $1;`
+	_, err := tx.Exec(ctx, sql, sale.CustomerID, amount, sale.ID)
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+	return nil
+}
+
+// useCredit - decreases store credit of the customer of the sale, it must be enough.
+// The credit is in the base currency, the amount is converted by the exchange rate of the sale.
+func useCredit(ctx context.Context, tx pgx.Tx, sale *types.Sale, amount int64) error {
+	sql := `UPDATE customers c SET credit = c.credit - v.amount
+			FROM (SELECT ROUND ($2 * rate) amount FROM sales WHERE id = $3) v
+			WHERE c.id = $1 AND c.credit >= v.amount;`
+	tag, err := tx.Exec(ctx, sql, sale.CustomerID, amount, sale.ID)
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+
+	if tag.RowsAffected() == 0 {
+		return &types.ValidationError{Field: "method", Reason: "insufficient_credit"}
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package sales
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/jackc/pgx/v4"
+
+	"github.com/SardorMS/CRUD/pkg/catalog"
+	"github.com/SardorMS/CRUD/pkg/types"
+)
+
+// Kinds of promotions.
+const (
+	KindPercent = "percent" // value percents off the price.
+	KindFixed   = "fixed"   // value off the price of each unit.
+	KindBuyGet  = "buy_get" // of each buy + get units, get units are free.
+)
+
+// promotion - rule of discount applicable to the sale.
+type promotion struct {
+	id         int64
+	kind       string
+	value      int64
+	buy        int
+	get        int
+	productID  int64
+	categories []int64 // scope category with subcategories.
+}
+
+// matches - checks that the product is in the scope of promotion.
+func (p *promotion) matches(productID int64, categoryID int64) bool {
+	switch {
+	case p.productID != 0:
+		return p.productID == productID
+	case len(p.categories) != 0:
+		for _, id := range p.categories {
+			if id == categoryID {
+				return true
+			}
+		}
+		return false
+	default:
+		return true
+	}
+}
+
+// discount - discount of the whole position in minor units.
+func (p *promotion) discount(position *types.SalePosition) int64 {
+	price := position.Price.Amount
+	qty := int64(position.Qty)
+
+	amount := int64(0)
+	switch p.kind {
+	case KindPercent:
+		amount = price * qty * p.value / 100
+	case KindFixed:
+		amount = p.value * qty
+	case KindBuyGet:
+		if p.buy+p.get > 0 {
+			amount = qty / int64(p.buy+p.get) * int64(p.get) * price
+		}
+	}
+
+	if amount > price*qty {
+		return price * qty
+	}
+	return amount
+}
+
+// promotionColumns - columns of promotion, the category of scope is selected with subcategories.
+var promotionColumns = `id, kind, value, buy, get, COALESCE (product_id, 0),
+						ARRAY (` + catalog.CategoryTree("promotions.category_id") + `)`
+
+// applyPromotions - gives each position the best discount of active promotions: automatic ones
+// and the one of promo code. The promo code must give discount at least to one position.
+// Fixed discounts are converted to the currency of sale.
+func applyPromotions(ctx context.Context, tx pgx.Tx, sale *types.Sale, products map[int64]*product, exchange *exchange) error {
+	rules := make([]*promotion, 0)
+
+	var code *promotion
+	if sale.PromoCode != "" {
+		var err error
+		code, err = codePromotion(ctx, tx, sale.PromoCode)
+		if err != nil {
+			return err
+		}
+		rules = append(rules, code)
+	}
+
+	sql := `SELECT ` + promotionColumns + ` FROM promotions
+			WHERE code IS NULL AND active
+			  AND (starts IS NULL OR starts <= CURRENT_TIMESTAMP)
+			  AND (ends IS NULL OR ends > CURRENT_TIMESTAMP);`
+	rows, err := tx.Query(ctx, sql)
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+	for rows.Next() {
+		rule := &promotion{}
+		err = rows.Scan(&rule.id, &rule.kind, &rule.value, &rule.buy, &rule.get, &rule.productID, &rule.categories)
+		if err != nil {
+			rows.Close()
+			log.Println(err)
+			return ErrInternal
+		}
+		rules = append(rules, rule)
+	}
+	rows.Close()
+
+	err = rows.Err()
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+
+	for _, rule := range rules {
+		if rule.kind == KindFixed {
+			rule.value = exchange.fromBase(rule.value)
+		}
+	}
+
+	codeApplied := false
+	for _, position := range sale.Positions {
+		position.Discount = types.NewMoney(0, sale.Currency)
+		position.PromotionID = 0
+		for _, rule := range rules {
+			if !rule.matches(position.ProductID, products[position.ProductID].categoryID) {
+				continue
+			}
+
+			if discount := rule.discount(position); discount > position.Discount.Amount {
+				position.Discount.Amount = discount
+				position.PromotionID = rule.id
+			}
+		}
+		position.Sum = position.Price.Mul(int64(position.Qty)).Sub(position.Discount)
+
+		if code != nil && position.PromotionID == code.id {
+			codeApplied = true
+		}
+	}
+
+	if code == nil {
+		return nil
+	}
+
+	if !codeApplied {
+		return &types.ValidationError{Field: "promo_code", Reason: "not_applicable"}
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE promotions SET used = used + 1 WHERE id = $1;`, code.id)
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+	return nil
+}
+
+// codePromotion - finds and locks the promotion of promo code, which must be active and not used up.
+func codePromotion(ctx context.Context, tx pgx.Tx, code string) (*promotion, error) {
+	rule := &promotion{}
+	var valid, usedUp bool
+
+	sql := `SELECT ` + promotionColumns + `,
+				   (starts IS NULL OR starts <= CURRENT_TIMESTAMP) AND (ends IS NULL OR ends > CURRENT_TIMESTAMP),
+				   usage_limit IS NOT NULL AND used >= usage_limit
+			FROM promotions WHERE code = $1 AND active FOR UPDATE;`
+	err := tx.QueryRow(ctx, sql, code).Scan(
+		&rule.id,
+		&rule.kind,
+		&rule.value,
+		&rule.buy,
+		&rule.get,
+		&rule.productID,
+		&rule.categories,
+		&valid,
+		&usedUp)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, &types.ValidationError{Field: "promo_code", Reason: "not_found"}
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+
+	if !valid {
+		return nil, &types.ValidationError{Field: "promo_code", Reason: "expired"}
+	}
+	if usedUp {
+		return nil, &types.ValidationError{Field: "promo_code", Reason: "used_up"}
+	}
+	return rule, nil
+}
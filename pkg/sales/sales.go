@@ -0,0 +1,363 @@
+package sales
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/SardorMS/CRUD/pkg/types"
+	"github.com/jackc/pgx/v4"
+)
+
+var (
+	ErrInternal          = errors.New("internal error")     // return when an internal error occurred.
+	ErrInsufficientStock = errors.New("insufficient stock") // return when product quantity isn't enough.
+)
+
+// InsufficientStockError - returned when product hasn't enough quantity for the sale.
+type InsufficientStockError struct {
+	ProductID int64
+	Requested int
+	Available int
+}
+
+func (e *InsufficientStockError) Error() string {
+	return fmt.Sprintf("insufficient stock of product %d: requested %d, available %d", e.ProductID, e.Requested, e.Available)
+}
+
+// Is - makes errors.Is(err, ErrInsufficientStock) true for any product.
+func (e *InsufficientStockError) Is(target error) bool {
+	return target == ErrInsufficientStock
+}
+
+// Override - allows to sell by price other than the price of product.
+type Override struct {
+	MaxDeviation int // percents of the price of product.
+}
+
+// product - locked product of the sale.
+type product struct {
+	categoryID   int64
+	price        int64 // in the currency of sale.
+	taxRate      int
+	taxInclusive bool
+}
+
+// Insert - makes the sale of manager or customer (manager_id is 0) in the transaction:
+// locks the products, decrements their stock, sets current prices, applies the promotions
+// and taxes and saves the sale with positions. The sale is made in the base currency if its
+// currency is empty. Price of the position is used only if override isn't nil, the change
+// of price is logged.
+func Insert(ctx context.Context, tx pgx.Tx, sale *types.Sale, pricing Pricing, override *Override) error {
+	if len(sale.Positions) == 0 {
+		return &types.ValidationError{Field: "positions", Reason: "empty"}
+	}
+	if sale.Currency == "" {
+		sale.Currency = pricing.Base
+	}
+
+	err := resolveBarcodes(ctx, tx, sale.Positions)
+	if err != nil {
+		return err
+	}
+
+	// total quantity by product, the same product can be in several positions.
+	quantities := make(map[int64]int)
+	for _, position := range sale.Positions {
+		if position.Qty <= 0 {
+			return &types.ValidationError{Field: "qty", Reason: "not_positive"}
+		}
+		if position.Price.Amount < 0 {
+			return &types.ValidationError{Field: "price", Reason: "negative"}
+		}
+		if position.Price.Currency != "" && position.Price.Currency != sale.Currency {
+			return &types.ValidationError{Field: "currency", Reason: "mismatch"}
+		}
+		quantities[position.ProductID] += position.Qty
+	}
+
+	exchange, err := exchangeOf(ctx, tx, pricing, sale.Currency)
+	if err != nil {
+		return err
+	}
+
+	products, err := lockStock(ctx, tx, quantities)
+	if err != nil {
+		return err
+	}
+
+	err = localPrices(ctx, tx, products, exchange)
+	if err != nil {
+		return err
+	}
+
+	overridden, err := setPrices(sale, products, override)
+	if err != nil {
+		return err
+	}
+
+	err = applyPromotions(ctx, tx, sale, products, exchange)
+	if err != nil {
+		return err
+	}
+
+	applyTaxes(sale, products)
+
+	sale.Tax = types.NewMoney(0, sale.Currency)
+	sale.Total = types.NewMoney(0, sale.Currency)
+	for _, position := range sale.Positions {
+		sale.Tax = sale.Tax.Add(position.Tax)
+		sale.Total = sale.Total.Add(position.Sum)
+	}
+
+	// sale which is free by promotions needs no payment.
+	sale.Paid = types.NewMoney(0, sale.Currency)
+	sale.Refunded = types.NewMoney(0, sale.Currency)
+	sale.Status = StatusPending
+	if sale.Total.IsZero() {
+		sale.Status = StatusPaid
+	}
+
+	sql := `INSERT INTO sales (manager_id, customer_id, status, currency, rate)
+			VALUES (NULLIF($1::BIGINT, 0), $2, $3, $4, $5::NUMERIC) RETURNING id, created;`
+	err = tx.QueryRow(ctx, sql, sale.ManagerID, sale.CustomerID, sale.Status, sale.Currency, exchange.String()).Scan(&sale.ID, &sale.Created)
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+
+	err = insertPositions(ctx, tx, sale)
+	if err != nil {
+		return err
+	}
+
+	return logOverrides(ctx, tx, sale, overridden, products)
+}
+
+// resolveBarcodes - sets the products of positions given by scanned barcode instead of id.
+func resolveBarcodes(ctx context.Context, tx pgx.Tx, positions []*types.SalePosition) error {
+	barcodes := make([]string, 0)
+	for _, position := range positions {
+		if position.ProductID == 0 && position.Barcode != "" {
+			barcodes = append(barcodes, position.Barcode)
+		}
+	}
+	if len(barcodes) == 0 {
+		return nil
+	}
+
+	rows, err := tx.Query(ctx, `SELECT barcode, id FROM products WHERE barcode = ANY ($1) AND active AND deleted_at IS NULL;`, barcodes)
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+	defer rows.Close()
+
+	ids := make(map[string]int64, len(barcodes))
+	for rows.Next() {
+		var barcode string
+		var id int64
+		err = rows.Scan(&barcode, &id)
+		if err != nil {
+			log.Println(err)
+			return ErrInternal
+		}
+		ids[barcode] = id
+	}
+
+	err = rows.Err()
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+
+	for _, position := range positions {
+		if position.ProductID == 0 && position.Barcode != "" {
+			position.ProductID = ids[position.Barcode]
+			if position.ProductID == 0 {
+				return &types.ValidationError{Field: "barcode", Reason: "not_found"}
+			}
+		}
+	}
+	return nil
+}
+
+// setPrices - sets current prices of products to the positions, or keeps the price of position
+// if override is allowed and the price deviates no more than allowed. Returns overridden positions.
+func setPrices(sale *types.Sale, products map[int64]*product, override *Override) ([]*types.SalePosition, error) {
+	overridden := make([]*types.SalePosition, 0)
+	for _, position := range sale.Positions {
+		price := products[position.ProductID].price
+		requested := position.Price.Amount
+		position.Price = types.NewMoney(price, sale.Currency)
+		if override == nil || requested == 0 || requested == price {
+			continue
+		}
+
+		deviation := requested - price
+		if deviation < 0 {
+			deviation = -deviation
+		}
+		if deviation*100 > price*int64(override.MaxDeviation) {
+			return nil, &types.ValidationError{Field: "price", Reason: "deviation_exceeded"}
+		}
+		position.Price.Amount = requested
+		overridden = append(overridden, position)
+	}
+	return overridden, nil
+}
+
+// logOverrides - saves the original and new prices of overridden positions.
+func logOverrides(ctx context.Context, tx pgx.Tx, sale *types.Sale, overridden []*types.SalePosition, products map[int64]*product) error {
+	if len(overridden) == 0 {
+		return nil
+	}
+
+	positions := make([]int64, len(overridden))
+	originals := make([]int64, len(overridden))
+	prices := make([]int64, len(overridden))
+	for i, position := range overridden {
+		positions[i] = position.ID
+		originals[i] = products[position.ProductID].price
+		prices[i] = position.Price.Amount
+	}
+
+	sql := `INSERT INTO price_overrides (sale_id, position_id, product_id, manager_id, original_price, price)
+			SELECT $1, sp.id, sp.product_id, $2, v.original_price, v.price
+			FROM unnest($3::BIGINT[], $4::BIGINT[], $5::BIGINT[]) v (position_id, original_price, price)
+			JOIN sale_positions sp ON sp.id = v.position_id;`
+	_, err := tx.Exec(ctx, sql, sale.ID, sale.ManagerID, positions, originals, prices)
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+
+	for _, position := range overridden {
+		log.Printf("price of product %d overridden by manager %d in sale %d: %s -> %s",
+			position.ProductID, sale.ManagerID, sale.ID, types.NewMoney(products[position.ProductID].price, sale.Currency), position.Price)
+	}
+	return nil
+}
+
+// lockStock - locks the products (ordered by id, so concurrent sales can't deadlock)
+// and decrements their quantity, the stock must be enough for all of them.
+// Returns the products by id.
+func lockStock(ctx context.Context, tx pgx.Tx, quantities map[int64]int) (map[int64]*product, error) {
+	ids := make([]int64, 0, len(quantities))
+	for id := range quantities {
+		ids = append(ids, id)
+	}
+
+	sql := `SELECT p.id, COALESCE (p.category_id, 0), p.price, p.qty, p.active AND p.deleted_at IS NULL, COALESCE (t.rate, 0), COALESCE (t.inclusive, TRUE)
+			FROM products p
+			LEFT JOIN tax_classes t ON t.id = p.tax_class_id
+			WHERE p.id = ANY($1) ORDER BY p.id FOR UPDATE OF p;`
+	rows, err := tx.Query(ctx, sql, ids)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	products := make(map[int64]*product, len(ids))
+	for rows.Next() {
+		var id int64
+		var qty int
+		var active bool
+		item := &product{}
+		err = rows.Scan(&id, &item.categoryID, &item.price, &qty, &active, &item.taxRate, &item.taxInclusive)
+		if err != nil {
+			log.Println(err)
+			return nil, ErrInternal
+		}
+
+		if !active {
+			return nil, &types.ValidationError{Field: "product_id", Reason: "inactive"}
+		}
+		if qty < quantities[id] {
+			return nil, &InsufficientStockError{ProductID: id, Requested: quantities[id], Available: qty}
+		}
+		products[id] = item
+	}
+
+	err = rows.Err()
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+
+	if len(products) != len(ids) {
+		return nil, &types.ValidationError{Field: "product_id", Reason: "not_found"}
+	}
+
+	qtys := make([]int, len(ids))
+	for i, id := range ids {
+		qtys[i] = quantities[id]
+	}
+
+	sql = `UPDATE products p SET qty = p.qty - v.qty, version = p.version + 1
+		   FROM unnest($1::BIGINT[], $2::INTEGER[]) v (id, qty)
+		   WHERE p.id = v.id;`
+	_, err = tx.Exec(ctx, sql, ids, qtys)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+	return products, nil
+}
+
+// insertPositions - saves the positions of the sale by one statement.
+func insertPositions(ctx context.Context, tx pgx.Tx, sale *types.Sale) error {
+	products := make([]int64, len(sale.Positions))
+	qtys := make([]int, len(sale.Positions))
+	prices := make([]int64, len(sale.Positions))
+	discounts := make([]int64, len(sale.Positions))
+	promotions := make([]int64, len(sale.Positions))
+	taxes := make([]int64, len(sale.Positions))
+	rates := make([]int, len(sale.Positions))
+	inclusive := make([]bool, len(sale.Positions))
+	for i, position := range sale.Positions {
+		products[i] = position.ProductID
+		qtys[i] = position.Qty
+		prices[i] = position.Price.Amount
+		discounts[i] = position.Discount.Amount
+		promotions[i] = position.PromotionID
+		taxes[i] = position.Tax.Amount
+		rates[i] = position.TaxRate
+		inclusive[i] = position.TaxInclusive
+	}
+
+	sql := `INSERT INTO sale_positions (sale_id, product_id, qty, price, discount, promotion_id, tax, tax_rate, tax_inclusive)
+			SELECT $1, v.product_id, v.qty, v.price, v.discount, NULLIF (v.promotion_id, 0), v.tax, v.tax_rate, v.tax_inclusive
+			FROM unnest($2::BIGINT[], $3::INTEGER[], $4::BIGINT[], $5::BIGINT[], $6::BIGINT[],
+						$7::BIGINT[], $8::INTEGER[], $9::BOOLEAN[])
+				 WITH ORDINALITY v (product_id, qty, price, discount, promotion_id, tax, tax_rate, tax_inclusive, n)
+			ORDER BY v.n
+			RETURNING id, created;`
+	rows, err := tx.Query(ctx, sql, sale.ID, products, qtys, prices, discounts, promotions, taxes, rates, inclusive)
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+	defer rows.Close()
+
+	for _, position := range sale.Positions {
+		if !rows.Next() {
+			break
+		}
+		err = rows.Scan(&position.ID, &position.Created)
+		if err != nil {
+			log.Println(err)
+			return ErrInternal
+		}
+		position.SaleID = sale.ID
+	}
+
+	err = rows.Err()
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+	return nil
+}
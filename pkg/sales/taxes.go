@@ -0,0 +1,35 @@
+package sales
+
+import "github.com/SardorMS/CRUD/pkg/types"
+
+// basisPoints - rate of 100%.
+const basisPoints = 10000
+
+// Tax - tax of the amount in minor units by rate in basis points. Inclusive tax is the part
+// of the amount, exclusive one is added to it. The tax is rounded half up.
+func Tax(amount int64, rate int, inclusive bool) int64 {
+	if inclusive {
+		return divRound(amount*int64(rate), basisPoints+int64(rate))
+	}
+	return divRound(amount*int64(rate), basisPoints)
+}
+
+// divRound - divides non-negative numbers rounding half up.
+func divRound(a int64, b int64) int64 {
+	return (a*2 + b) / (b * 2)
+}
+
+// applyTaxes - computes the tax of each position after discount by the tax class of its product,
+// exclusive tax is added to the sum of position. The tax is computed per position, so the sum
+// of positions is the total of the sale.
+func applyTaxes(sale *types.Sale, products map[int64]*product) {
+	for _, position := range sale.Positions {
+		item := products[position.ProductID]
+		position.TaxRate = item.taxRate
+		position.TaxInclusive = item.taxInclusive
+		position.Tax = types.NewMoney(Tax(position.Sum.Amount, item.taxRate, item.taxInclusive), position.Sum.Currency)
+		if !position.TaxInclusive {
+			position.Sum = position.Sum.Add(position.Tax)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package sales
+
+import "testing"
+
+func TestTax(t *testing.T) {
+	tests := []struct {
+		name      string
+		amount    int64
+		rate      int
+		inclusive bool
+		want      int64
+	}{
+		{"exclusive", 10000, 1200, false, 1200},
+		{"exclusive rounded down", 4, 1000, false, 0},
+		{"exclusive half rounded up", 5, 1000, false, 1},
+		{"exclusive fraction of basis point", 333, 1250, false, 42},
+		{"inclusive", 11200, 1200, true, 1200},
+		{"inclusive rounded up", 100, 2000, true, 17},
+		{"inclusive rounded down", 100, 1200, true, 11},
+		{"inclusive half rounded up", 21, 10000, true, 11},
+		{"zero rate", 12345, 0, false, 0},
+		{"zero rate inclusive", 12345, 0, true, 0},
+		{"zero amount", 0, 1200, true, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Tax(test.amount, test.rate, test.inclusive); got != test.want {
+				t.Errorf("Tax(%d, %d, %v) = %d, want %d", test.amount, test.rate, test.inclusive, got, test.want)
+			}
+		})
+	}
+}
+
+func TestTaxInclusivePartOfAmount(t *testing.T) {
+	// inclusive tax must never exceed the amount and the rest must be the net price.
+	for amount := int64(0); amount <= 1000; amount++ {
+		for _, rate := range []int{500, 1200, 2000, 10000} {
+			tax := Tax(amount, rate, true)
+			if tax < 0 || tax > amount {
+				t.Fatalf("Tax(%d, %d, true) = %d, out of the amount", amount, rate, tax)
+			}
+			net := amount - tax
+			if back := Tax(net, rate, false); back < tax-1 || back > tax+1 {
+				t.Fatalf("Tax(%d, %d, true) = %d, exclusive tax of net %d is %d", amount, rate, tax, net, back)
+			}
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package sms
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Sender - sends text messages to phones.
+type Sender interface {
+	Send(ctx context.Context, phone string, text string) error
+}
+
+// LogSender - writes messages to log, for local use.
+type LogSender struct{}
+
+// Send - writes the message to log.
+func (LogSender) Send(ctx context.Context, phone string, text string) error {
+	log.Printf("SMS to %s: %s", phone, text)
+	return nil
+}
+
+// FileSender - appends messages to file, for local use.
+type FileSender struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewFileSender - creates a sender which writes to file by path.
+func NewFileSender(path string) *FileSender {
+	return &FileSender{path: path}
+}
+
+// Send - appends the message to file.
+func (s *FileSender) Send(ctx context.Context, phone string, text string) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	_, err = fmt.Fprintf(file, "%s\t%s\t%s\n", time.Now().Format(time.RFC3339), phone, text)
+	return err
+}
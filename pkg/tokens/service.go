@@ -0,0 +1,263 @@
+package tokens
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+
+	"github.com/SardorMS/CRUD/pkg/config"
+)
+
+// Kinds of token subjects.
+const (
+	KindCustomer = "customer"
+	KindManager  = "manager"
+)
+
+// Modes of tokens.
+const (
+	ModeOpaque  = "opaque"  // random tokens, checked by database.
+	ModeHMAC    = "hmac"    // tokens signed by HMAC-SHA256.
+	ModeEd25519 = "ed25519" // tokens signed by Ed25519.
+)
+
+var (
+	ErrInternal     = errors.New("internal error")      //return when an internal error occurred.
+	ErrInvalidToken = errors.New("invalid token")       //return when token is malformed or signature is wrong.
+	ErrTokenExpired = errors.New("token expired")       //return when token expired.
+	ErrTokenRevoked = errors.New("token revoked")       //return when token is in deny-list.
+	ErrInvalidKey   = errors.New("invalid signing key") //return when key of configured mode is missing.
+)
+
+// Claims - the data carried by signed token.
+type Claims struct {
+	ID       string   `json:"jti"`
+	Subject  int64    `json:"sub"`
+	Kind     string   `json:"kind"`
+	Roles    []string `json:"roles,omitempty"`
+	IssuedAt int64    `json:"iat"`
+	Expire   int64    `json:"exp"`
+}
+
+// header - header of signed token.
+type header struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+}
+
+// Service - issues and verifies signed tokens, keeps the deny-list of revoked ones.
+type Service struct {
+	pool   *pgxpool.Pool
+	signer signer
+
+	mu     sync.RWMutex
+	denied map[string]time.Time
+}
+
+// NewService - create a service, signer is chosen by configured mode
+// (in opaque mode service is disabled).
+func NewService(pool *pgxpool.Pool, cfg *config.Config) (*Service, error) {
+	s := &Service{pool: pool, denied: make(map[string]time.Time)}
+
+	switch cfg.TokenMode {
+	case ModeOpaque:
+		return s, nil
+	case ModeHMAC:
+		if len(cfg.TokenSecret) < 32 {
+			return nil, ErrInvalidKey
+		}
+		s.signer = &hmacSigner{secret: []byte(cfg.TokenSecret)}
+	case ModeEd25519:
+		seed, err := base64.StdEncoding.DecodeString(cfg.TokenSecret)
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return nil, ErrInvalidKey
+		}
+		private := ed25519.NewKeyFromSeed(seed)
+		s.signer = &ed25519Signer{private: private, public: private.Public().(ed25519.PublicKey)}
+	default:
+		return nil, ErrInvalidKey
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	if err := s.loadDenied(ctx); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Enabled - reports whether signed tokens are used.
+func (s *Service) Enabled() bool {
+	return s.signer != nil
+}
+
+// Issue - issues a signed token with claims, which expires after ttl.
+func (s *Service) Issue(kind string, subject int64, roles []string, ttl time.Duration) (string, time.Time, error) {
+
+	buffer := make([]byte, 16)
+	n, err := rand.Read(buffer)
+	if n != len(buffer) || err != nil {
+		return "", time.Time{}, ErrInternal
+	}
+
+	now := time.Now()
+	expire := now.Add(ttl)
+	claims := &Claims{
+		ID:       hex.EncodeToString(buffer),
+		Subject:  subject,
+		Kind:     kind,
+		Roles:    roles,
+		IssuedAt: now.Unix(),
+		Expire:   expire.Unix(),
+	}
+
+	head, err := json.Marshal(&header{Alg: s.signer.alg(), Typ: "JWT"})
+	if err != nil {
+		log.Println(err)
+		return "", time.Time{}, ErrInternal
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		log.Println(err)
+		return "", time.Time{}, ErrInternal
+	}
+
+	encoding := base64.RawURLEncoding
+	data := encoding.EncodeToString(head) + "." + encoding.EncodeToString(payload)
+	signature := s.signer.sign([]byte(data))
+
+	return data + "." + encoding.EncodeToString(signature), expire, nil
+}
+
+// Verify - verifies signature, expiry and deny-list of the token without database.
+func (s *Service) Verify(token string) (*Claims, error) {
+
+	claims, err := s.parse(token)
+	if err != nil {
+		return nil, err
+	}
+
+	if time.Now().Unix() >= claims.Expire {
+		return nil, ErrTokenExpired
+	}
+
+	s.mu.RLock()
+	_, denied := s.denied[claims.ID]
+	s.mu.RUnlock()
+
+	if denied {
+		return nil, ErrTokenRevoked
+	}
+	return claims, nil
+}
+
+// Revoke - adds the token to deny-list until it expires.
+func (s *Service) Revoke(ctx context.Context, token string) error {
+
+	claims, err := s.parse(token)
+	if err != nil {
+		return err
+	}
+
+	expire := time.Unix(claims.Expire, 0)
+	if time.Now().After(expire) {
+		return nil
+	}
+
+	sql := `INSERT INTO revoked_tokens (jti, expire) VALUES ($1, $2) ON CONFLICT DO NOTHING;`
+	_, err = s.pool.Exec(ctx, sql, claims.ID, expire.UTC())
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for id, until := range s.denied {
+		if now.After(until) {
+			delete(s.denied, id)
+		}
+	}
+	s.denied[claims.ID] = expire
+
+	return nil
+}
+
+// parse - verifies signature of the token and decodes its claims.
+func (s *Service) parse(token string) (*Claims, error) {
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	encoding := base64.RawURLEncoding
+	signature, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if !s.signer.verify([]byte(parts[0]+"."+parts[1]), signature) {
+		return nil, ErrInvalidToken
+	}
+
+	head := &header{}
+	data, err := encoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(data, head) != nil || head.Alg != s.signer.alg() {
+		return nil, ErrInvalidToken
+	}
+
+	claims := &Claims{}
+	data, err = encoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if err = json.Unmarshal(data, claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// loadDenied - loads the deny-list of tokens, which aren't expired yet.
+func (s *Service) loadDenied(ctx context.Context) error {
+
+	sql := `SELECT jti, expire FROM revoked_tokens WHERE expire > $1;`
+	rows, err := s.pool.Query(ctx, sql, time.Now().UTC())
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		var expire time.Time
+		if err = rows.Scan(&id, &expire); err != nil {
+			log.Println(err)
+			return ErrInternal
+		}
+		s.denied[id] = expire
+	}
+
+	err = rows.Err()
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package tokens
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+)
+
+// signer - signs and verifies the tokens.
+type signer interface {
+	alg() string
+	sign(data []byte) []byte
+	verify(data []byte, signature []byte) bool
+}
+
+// hmacSigner - HMAC-SHA256 signature (HS256).
+type hmacSigner struct {
+	secret []byte
+}
+
+func (s *hmacSigner) alg() string {
+	return "HS256"
+}
+
+func (s *hmacSigner) sign(data []byte) []byte {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func (s *hmacSigner) verify(data []byte, signature []byte) bool {
+	return hmac.Equal(s.sign(data), signature)
+}
+
+// ed25519Signer - Ed25519 signature (EdDSA).
+type ed25519Signer struct {
+	private ed25519.PrivateKey
+	public  ed25519.PublicKey
+}
+
+func (s *ed25519Signer) alg() string {
+	return "EdDSA"
+}
+
+func (s *ed25519Signer) sign(data []byte) []byte {
+	return ed25519.Sign(s.private, data)
+}
+
+func (s *ed25519Signer) verify(data []byte, signature []byte) bool {
+	return ed25519.Verify(s.public, data, signature)
+}
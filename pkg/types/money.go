@@ -0,0 +1,92 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Money - amount in minor units (e.g. cents) of the currency by ISO 4217 code.
+type Money struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// minorUnits - digits of minor units of currencies which differ from 2.
+var minorUnits = map[string]int{
+	"BHD": 3,
+	"IQD": 3,
+	"JOD": 3,
+	"JPY": 0,
+	"KRW": 0,
+	"KWD": 3,
+	"OMR": 3,
+	"TND": 3,
+	"VND": 0,
+}
+
+// MinorUnits - returns the digits of minor units of the currency.
+func MinorUnits(currency string) int {
+	if digits, ok := minorUnits[currency]; ok {
+		return digits
+	}
+	return 2
+}
+
+// ValidCurrency - checks that the code looks like ISO 4217 code: three capital letters.
+func ValidCurrency(currency string) bool {
+	if len(currency) != 3 {
+		return false
+	}
+	for _, r := range currency {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// NewMoney - returns the amount in minor units of the currency.
+func NewMoney(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+// Add - returns the sum of the amounts, the currency is taken from m.
+func (m Money) Add(other Money) Money {
+	return Money{Amount: m.Amount + other.Amount, Currency: m.Currency}
+}
+
+// Sub - returns the difference of the amounts, the currency is taken from m.
+func (m Money) Sub(other Money) Money {
+	return Money{Amount: m.Amount - other.Amount, Currency: m.Currency}
+}
+
+// Mul - returns the amount multiplied by n.
+func (m Money) Mul(n int64) Money {
+	return Money{Amount: m.Amount * n, Currency: m.Currency}
+}
+
+// IsZero - checks that the amount is 0.
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+// String - formats the amount in major units with the currency, e.g. 1250.50 USD.
+func (m Money) String() string {
+	amount := m.Amount
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+
+	digits := MinorUnits(m.Currency)
+	if digits == 0 {
+		return strings.TrimSpace(fmt.Sprintf("%s%d %s", sign, amount, m.Currency))
+	}
+
+	scale := int64(1)
+	for i := 0; i < digits; i++ {
+		scale *= 10
+	}
+	return strings.TrimSpace(fmt.Sprintf("%s%d.%0*d %s", sign, amount/scale, digits, amount%scale, m.Currency))
+}
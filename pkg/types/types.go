@@ -2,6 +2,43 @@ package types
 
 import "time"
 
+// ValidationError - returned when the request doesn't satisfy the rules.
+type ValidationError struct {
+	Field  string `json:"field"`
+	Reason string `json:"error"`
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid " + e.Field + ": " + e.Reason
+}
+
+// Login - record of login attempt of customer or manager.
+type Login struct {
+	ID        int64     `json:"id"`
+	Kind      string    `json:"kind"`
+	Phone     string    `json:"phone"`
+	UserID    int64     `json:"user_id"`
+	Success   bool      `json:"success"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Created   time.Time `json:"created"`
+}
+
+// LoginFilter - conditions of login history search.
+type LoginFilter struct {
+	Kind    string
+	Phone   string
+	IP      string
+	Success *bool
+	Limit   int
+}
+
+// PasswordChange - request to change the password.
+type PasswordChange struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
 //-----------------------------Customers-----------------------//
 
 type Customer struct {
@@ -27,26 +64,133 @@ type Auth struct {
 
 // Token - ...
 type Token struct {
-	Token string `json:"token"`
+	Token         string    `json:"token"`
+	Expire        time.Time `json:"expire"`
+	RefreshToken  string    `json:"refresh_token"`
+	RefreshExpire time.Time `json:"refresh_expire"`
+}
+
+// Refresh - request to exchange the refresh token for a new token.
+type Refresh struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Client - describes the client which requested the token.
+type Client struct {
+	UserAgent string
+	IP        string
+}
+
+// Session - represents an active token of customer or manager.
+type Session struct {
+	ID            int64     `json:"id"`
+	UserAgent     string    `json:"user_agent"`
+	IP            string    `json:"ip"`
+	Current       bool      `json:"current"`
+	Expire        time.Time `json:"expire"`
+	RefreshExpire time.Time `json:"refresh_expire"`
+	LastUsed      time.Time `json:"last_used"`
+	Created       time.Time `json:"created"`
+}
+
+// PasswordResetRequest - request to send the code to reset the password.
+type PasswordResetRequest struct {
+	Phone string `json:"phone"`
+}
+
+// PasswordReset - request to set a new password by the code.
+type PasswordReset struct {
+	Phone    string `json:"phone"`
+	Code     string `json:"code"`
+	Password string `json:"password"`
 }
 
 // Product - ...
 type Product struct {
-	ID    int64  `json:"id"`
-	Name  string `json:"name"`
-	Price int    `json:"price"`
-	Qty   int    `json:"qty"`
+	ID          int64  `json:"id"`
+	Name        string `json:"name"`
+	CategoryID  int64  `json:"category_id"`
+	SKU         string `json:"sku"`
+	Barcode     string `json:"barcode"`
+	Description string `json:"description"`
+	Unit        string `json:"unit"`
+	Price       Money  `json:"price"`
+	Qty         int    `json:"qty"`
+}
+
+// ProductFilter - conditions of products search.
+type ProductFilter struct {
+	Query      string // words of name, description or SKU, similar words match too.
+	CategoryID int64  // with subcategories, 0 - any.
+	MinPrice   *int64 // in the base currency.
+	MaxPrice   *int64
+	InStock    bool
+	Active     *bool  // nil - active and inactive.
+	Archived   bool   // archived products are found too.
+	Sort       string // field:direction, e.g. price:asc.
+	Cursor     string // position after the previous page.
+	Limit      int
+}
+
+// Page - position of the page in the search results.
+type Page struct {
+	Total int64  // count of all found items.
+	Next  string // cursor of the next page, empty on the last page.
 }
 
 //Purchases (sales) - ...
 type Sales struct {
 	ID      int64     `json:"id"`
 	Name    string    `json:"name"`
-	Price   int       `json:"price"`
+	Price   Money     `json:"price"`
 	Qty     int       `json:"qty"`
 	Created time.Time `json:"created"`
 }
 
+// CartItem - product in the cart of customer with its current price.
+type CartItem struct {
+	ProductID int64  `json:"product_id"`
+	Name      string `json:"name"`
+	Price     Money  `json:"price"`
+	Qty       int    `json:"qty"`
+	Sum       Money  `json:"sum"`
+}
+
+// Cart - products which customer is going to buy.
+type Cart struct {
+	Items []*CartItem `json:"items"`
+	Total Money       `json:"total"`
+}
+
+// Receipt - information about completed sale.
+type Receipt struct {
+	SaleID     int64              `json:"sale_id"`
+	CustomerID int64              `json:"customer_id"`
+	Positions  []*ReceiptPosition `json:"positions"`
+	Discount   Money              `json:"discount"`
+	Tax        Money              `json:"tax"`
+	Total      Money              `json:"total"`
+	Created    time.Time          `json:"created"`
+}
+
+// ReceiptPosition - sold product in the receipt.
+type ReceiptPosition struct {
+	ID        int64  `json:"id"`
+	ProductID int64  `json:"product_id"`
+	Name      string `json:"name"`
+	Price     Money  `json:"price"`
+	Qty       int    `json:"qty"`
+	Discount  Money  `json:"discount"`
+	Tax       Money  `json:"tax"`
+	Sum       Money  `json:"sum"`
+}
+
+// Checkout - request to buy the products of the cart.
+type Checkout struct {
+	PromoCode string `json:"promo_code"`
+	Currency  string `json:"currency"`
+}
+
 //
 //--------------------------Managers-------------------------//
 //
@@ -59,6 +203,19 @@ type ManagerRegister struct {
 	Roles []string `json:"roles"`
 }
 
+// SetupCode - one-time code to set up the password of registred manager.
+type SetupCode struct {
+	ManagerID int64     `json:"manager_id"`
+	Code      string    `json:"code"`
+	Expire    time.Time `json:"expire"`
+}
+
+// PasswordSetup - request to set up the password by one-time code.
+type PasswordSetup struct {
+	Code     string `json:"code"`
+	Password string `json:"password"`
+}
+
 //Managers - represents information about customers.
 type Managers struct {
 	ID         int64     `json:"id"`
@@ -69,44 +226,201 @@ type Managers struct {
 	Plan       int64     `json:"plan"`
 	BossID     int64     `json:"boss_id"`
 	Department string    `json:"department"`
-	IsAdmin    bool      `json:"is_admin"`
+	Roles      []string  `json:"roles"`
 	Created    time.Time `json:"created"`
 }
 
+// Role - named group of permissions.
+type Role struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Permissions []string  `json:"permissions"`
+	Created     time.Time `json:"created"`
+}
+
+// StockAdjustment - change of the stock quantity of product, negative for write-off.
+type StockAdjustment struct {
+	Qty int `json:"qty"`
+}
+
+// ManagerRoles - roles assigned to manager.
+type ManagerRoles struct {
+	Roles []string `json:"roles"`
+}
+
 // Sale - ...
 type Sale struct {
 	ID         int64           `json:"id"`
 	ManagerID  int64           `json:"manager_id"`
 	CustomerID int64           `json:"customer_id"`
+	PromoCode  string          `json:"promo_code,omitempty"`
+	Status     string          `json:"status"`
+	Currency   string          `json:"currency"`
+	Tax        Money           `json:"tax"`
+	Total      Money           `json:"total"`
+	Paid       Money           `json:"paid"`
+	Refunded   Money           `json:"refunded"`
 	Created    time.Time       `json:"created"`
-	Positions  []*SalePosition `json:"positions"`
+	Positions  []*SalePosition `json:"positions,omitempty"`
+	Payments   []*Payment      `json:"payments,omitempty"`
+	Refunds    []*Refund       `json:"refunds,omitempty"`
+}
+
+// Payment - payment of the sale by one method. In cash the customer can tender more
+// than the amount and get the change.
+type Payment struct {
+	ID        int64     `json:"id"`
+	SaleID    int64     `json:"sale_id"`
+	Method    string    `json:"method"`
+	Amount    Money     `json:"amount"`
+	Tendered  Money     `json:"tendered"`
+	Change    Money     `json:"change"`
+	ManagerID int64     `json:"manager_id"`
+	Created   time.Time `json:"created"`
+}
+
+// Refund - money given back for the payment when the sale is voided or products are returned.
+type Refund struct {
+	ID        int64     `json:"id"`
+	SaleID    int64     `json:"sale_id"`
+	PaymentID int64     `json:"payment_id"`
+	ReturnID  int64     `json:"return_id,omitempty"`
+	Method    string    `json:"method"`
+	Amount    Money     `json:"amount"`
+	ManagerID int64     `json:"manager_id"`
+	Created   time.Time `json:"created"`
 }
 
 // SalePosition - ...
 type SalePosition struct {
+	ID           int64     `json:"id"`
+	ProductID    int64     `json:"product_id"`
+	Barcode      string    `json:"barcode,omitempty"` // scanned instead of product_id.
+	SaleID       int64     `json:"sale_id"`
+	Name         string    `json:"name,omitempty"`
+	Price        Money     `json:"price"`
+	Qty          int       `json:"qty"`
+	Discount     Money     `json:"discount"`
+	PromotionID  int64     `json:"promotion_id,omitempty"`
+	Tax          Money     `json:"tax"`
+	TaxRate      int       `json:"tax_rate"`
+	TaxInclusive bool      `json:"tax_inclusive"`
+	Sum          Money     `json:"sum"`
+	Created      time.Time `json:"created"`
+}
+
+// Category - group of products.
+type Category struct {
+	ID       int64     `json:"id"`
+	ParentID int64     `json:"parent_id"` // 0 for top level category.
+	Name     string    `json:"name"`
+	Created  time.Time `json:"created"`
+}
+
+// ExchangeRate - price of one unit of the currency in units of the base currency.
+type ExchangeRate struct {
+	Currency string    `json:"currency"`
+	Rate     string    `json:"rate"`
+	Updated  time.Time `json:"updated"`
+}
+
+// TaxClass - tax rate of products in basis points (1200 - 12%), the tax is included
+// in the price or added to it.
+type TaxClass struct {
 	ID        int64     `json:"id"`
-	ProductID int64     `json:"product_id"`
-	SaleID    int64     `json:"sale_id"`
-	Price     int       `json:"price"`
-	Qty       int       `json:"qty"`
+	Name      string    `json:"name"`
+	Rate      int       `json:"rate"`
+	Inclusive bool      `json:"inclusive"`
 	Created   time.Time `json:"created"`
 }
 
+// Promotion - discount of percent, fixed amount off each unit or "buy N get M",
+// applied to the product, the category or all products automatically or by promo code.
+// Fixed amount is in minor units of the base currency.
+type Promotion struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	Kind       string     `json:"kind"`
+	Value      int64      `json:"value"`
+	Buy        int        `json:"buy"`
+	Get        int        `json:"get"`
+	ProductID  int64      `json:"product_id"`
+	CategoryID int64      `json:"category_id"`
+	Code       string     `json:"code"`
+	UsageLimit int        `json:"usage_limit"`
+	Used       int        `json:"used"`
+	Starts     *time.Time `json:"starts"`
+	Ends       *time.Time `json:"ends"`
+	Active     bool       `json:"active"`
+	Created    time.Time  `json:"created"`
+}
+
+// SaleFilter - conditions of sales search, zero values aren't used.
+type SaleFilter struct {
+	From       *time.Time
+	To         *time.Time
+	CustomerID int64
+	ProductID  int64
+	ManagerID  int64
+	Status     string
+	Currency   string
+	MinTotal   *int64
+	MaxTotal   *int64
+	Sort       string
+	Limit      int
+	Offset     int
+}
+
+// SaleReturn - return of products sold by the sale.
+type SaleReturn struct {
+	ID        int64             `json:"id"`
+	SaleID    int64             `json:"sale_id"`
+	ManagerID int64             `json:"manager_id"`
+	Reason    string            `json:"reason"`
+	Amount    Money             `json:"amount"`
+	Created   time.Time         `json:"created"`
+	Positions []*ReturnPosition `json:"positions"`
+	Refunds   []*Refund         `json:"refunds"`
+}
+
+// ReturnPosition - returned quantity of sold position.
+type ReturnPosition struct {
+	ID         int64     `json:"id"`
+	PositionID int64     `json:"position_id"`
+	ProductID  int64     `json:"product_id"`
+	Price      Money     `json:"price"`
+	Qty        int       `json:"qty"`
+	Amount     Money     `json:"amount"`
+	Tax        Money     `json:"tax"`
+	Created    time.Time `json:"created"`
+}
+
 // - Products - ...
 type Products struct {
-	ID      int64     `json:"id"`
-	Name    string    `json:"name"`
-	Price   int       `json:"price"`
-	Qty     int       `json:"qty"`
-	Active  bool      `json:"active"`
-	Created time.Time `json:"created"`
+	ID          int64      `json:"id"`
+	Name        string     `json:"name"`
+	CategoryID  int64      `json:"category_id"`
+	TaxClassID  int64      `json:"tax_class_id"`
+	SKU         string     `json:"sku"`
+	Barcode     string     `json:"barcode"`
+	Description string     `json:"description"`
+	Unit        string     `json:"unit"`
+	Price       Money      `json:"price"`            // in the base currency.
+	Prices      []Money    `json:"prices,omitempty"` // own prices in other currencies.
+	Qty         int        `json:"qty"`
+	Active      bool       `json:"active"`
+	Version     int64      `json:"version"`              // changed with each change of product.
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"` // set when product is archived.
+	Created     time.Time  `json:"created"`
 }
 
 // Customers - ...
 type Customers struct {
-	ID      int64     `json:"id"`
-	Name    string    `json:"name"`
-	Phone   string    `json:"phone"`
-	Active  bool      `json:"active"`
-	Created time.Time `json:"created"`
+	ID        int64      `json:"id"`
+	Name      string     `json:"name"`
+	Phone     string     `json:"phone"`
+	Active    bool       `json:"active"`
+	Version   int64      `json:"version"`              // changed with each change of customer.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"` // set when customer is archived.
+	Created   time.Time  `json:"created"`
 }